@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+const (
+	auditLogin              = "auth.login"
+	auditRefreshTokenRevoke = "auth.refresh_token.revoke"
+	auditUserCreate         = "user.create"
+	auditPasswordChange     = "user.password.change"
+	auditEmailChange        = "user.email.change"
+	auditUserDelete         = "user.delete"
+	auditChirpDelete        = "chirp.delete"
+	auditRedUpgrade         = "subscription.red.upgrade"
+	auditRoleChange         = "role.change"
+	auditAccessTokenCreate  = "access_token.create"
+	auditAccessTokenRevoke  = "access_token.revoke"
+	auditOAuthClientCreate  = "oauth.client.create"
+	auditOAuthAuthorize     = "oauth.authorize"
+	auditOAuthToken         = "oauth.token"
+
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+	outcomeDenied  = "denied"
+)
+
+// recordAudit appends a security event to the audit log, a failure to write
+// is logged but never fails the request that triggered it
+func recordAudit(r *http.Request, actorId int, action string, target string, outcome string) {
+	auditLog, err := database.NewAuditLog("./audit.log")
+	if err != nil {
+		log.Printf("Unable to open audit log: %s", err)
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	err = auditLog.Append(database.AuditEntry{
+		Time:      time.Now().UTC(),
+		ActorId:   actorId,
+		Action:    action,
+		Target:    target,
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+		Outcome:   outcome,
+	})
+	if err != nil {
+		log.Printf("Unable to write audit log: %s", err)
+	}
+}
+
+func userTarget(usrId int) string {
+	return "user:" + strconv.Itoa(usrId)
+}
+
+func auditHandle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := database.AuditFilter{
+		Action:  query.Get("action"),
+		Target:  query.Get("target"),
+		Outcome: query.Get("outcome"),
+	}
+	if actor := query.Get("actor_id"); actor != "" {
+		actorId, err := strconv.Atoi(actor)
+		if err != nil {
+			respondWithError(w, 400, "Invalid actor_id")
+			return
+		}
+		filter.ActorId = actorId
+	}
+	for param, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if raw := query.Get(param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				respondWithError(w, 400, "Invalid "+param+", expected RFC 3339")
+				return
+			}
+			*dest = parsed
+		}
+	}
+
+	auditLog, err := database.NewAuditLog("./audit.log")
+	if err != nil {
+		respondWithError(w, 500, "Unable to open audit log")
+		return
+	}
+	entries, err := auditLog.Query(filter)
+	if err != nil {
+		respondWithError(w, 500, "Unable to read audit log")
+		return
+	}
+
+	if query.Get("format") == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+		w.WriteHeader(200)
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			encoder.Encode(entry)
+		}
+		return
+	}
+	respondWithJSON(w, 200, entries)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+const (
+	scopeChirpsRead   = "chirps:read"
+	scopeChirpsWrite  = "chirps:write"
+	scopeProfileWrite = "profile:write"
+	// scopeAccount is only held by password logins, it guards
+	// credentials, roles and everything else a token must not touch
+	scopeAccount = "account"
+
+	accessTokenPrefix = "chirpy_pat_"
+)
+
+var errInsufficientScope = errors.New("token is missing the required scope")
+
+// grantableScopes are the scopes a personal access token may be created with
+var grantableScopes = []string{scopeChirpsRead, scopeChirpsWrite, scopeProfileWrite}
+
+// authInfo describes who made a request and what they are allowed to do
+type authInfo struct {
+	UserId int
+	// Scopes is nil for password logins, which may do anything
+	Scopes []string
+}
+
+func (a authInfo) hasScope(scope string) bool {
+	return a.Scopes == nil || slices.Contains(a.Scopes, scope)
+}
+
+// authorize validates the bearer token on the request, which may be either a
+// JWT from a login or a personal access token, and checks it grants scope
+func (cfg *apiConfig) authorize(r *http.Request, scope string) (authInfo, error) {
+	authToken := r.Header.Get("Authorization")
+	tokenParts := strings.Split(authToken, " ")
+	if len(tokenParts) != 2 {
+		return authInfo{}, fmt.Errorf("invalid token recieved")
+	}
+	if tokenParts[0] != "Bearer" {
+		return authInfo{}, fmt.Errorf("invalid token type")
+	}
+
+	var info authInfo
+	var err error
+	if strings.HasPrefix(tokenParts[1], accessTokenPrefix) {
+		info, err = checkAccessToken(tokenParts[1])
+	} else {
+		info, err = cfg.checkJWT(tokenParts[1])
+	}
+	if err != nil {
+		return authInfo{}, err
+	}
+
+	if !info.hasScope(scope) {
+		return authInfo{}, errInsufficientScope
+	}
+	return info, nil
+}
+
+// userIdFromRequest authorizes the request for full account access
+// and returns the id of the user making it
+func (cfg *apiConfig) userIdFromRequest(r *http.Request) (int, error) {
+	info, err := cfg.authorize(r, scopeAccount)
+	if err != nil {
+		return 0, err
+	}
+	return info.UserId, nil
+}
+
+// viewerId identifies the caller on endpoints that also work anonymously,
+// it returns 0 when there is no usable token with chirps:read
+func (cfg *apiConfig) viewerId(r *http.Request) int {
+	if r.Header.Get("Authorization") == "" {
+		return 0
+	}
+	info, err := cfg.authorize(r, scopeChirpsRead)
+	if err != nil {
+		return 0
+	}
+	return info.UserId
+}
+
+// respondWithAuthError reports a failed authorize call, unauthorizedCode
+// lets older endpoints keep the status they have always returned
+func respondWithAuthError(w http.ResponseWriter, err error, unauthorizedCode int) {
+	if errors.Is(err, errInsufficientScope) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	respondWithError(w, unauthorizedCode, fmt.Sprintf("Incorrect token: %s", err))
+}
+
+// chirpyClaims are the claims on every JWT we issue, OAuth2 clients
+// additionally get the client id and the scopes the user consented to
+type chirpyClaims struct {
+	Scope    string `json:"scope,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// issueJWT signs an access token for a user, scopes are only
+// passed for tokens issued to OAuth2 clients
+func (cfg *apiConfig) issueJWT(usrId int, expiresIn time.Duration, clientId string, scopes []string) (string, error) {
+	currentTime := time.Now()
+	claims := chirpyClaims{
+		Scope:    strings.Join(scopes, " "),
+		ClientId: clientId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(currentTime),
+			ExpiresAt: jwt.NewNumericDate(currentTime.Add(expiresIn)),
+			Subject:   fmt.Sprintf("%d", usrId),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(cfg.jwtSecret))
+}
+
+func (cfg *apiConfig) checkJWT(tokenStr string) (authInfo, error) {
+	tokenClaims := &chirpyClaims{}
+	plainToken, err := jwt.ParseWithClaims(tokenStr, tokenClaims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return []byte(cfg.jwtSecret), nil
+	})
+	if err != nil {
+		return authInfo{}, err
+	}
+	usrId, err := plainToken.Claims.GetSubject()
+	if err != nil {
+		return authInfo{}, err
+	}
+	intId, err := strconv.Atoi(usrId)
+	if err != nil {
+		return authInfo{}, err
+	}
+
+	info := authInfo{UserId: intId}
+	if tokenClaims.ClientId != "" || tokenClaims.Scope != "" {
+		info.Scopes = append([]string{}, strings.Fields(tokenClaims.Scope)...)
+	}
+	return info, nil
+}
+
+func checkAccessToken(tokenStr string) (authInfo, error) {
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		return authInfo{}, err
+	}
+	token, err := dbHandle.CheckAccessToken(hashSecret(tokenStr))
+	if err != nil {
+		return authInfo{}, err
+	}
+	return authInfo{UserId: token.UserId, Scopes: append([]string{}, token.Scopes...)}, nil
+}
+
+// hashSecret is used for every secret we need to recognise but never read
+// back: personal access tokens, client secrets and authorization codes
+func hashSecret(tokenStr string) string {
+	sum := sha256.Sum256([]byte(tokenStr))
+	return hex.EncodeToString(sum[:])
+}
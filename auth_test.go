@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestAuthorize(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{jwtSecret: "secret"}
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+
+	createPAT := func(id string, expiresAt *time.Time, scopes ...string) string {
+		tokenStr := accessTokenPrefix + id
+		_, err := dbHandle.CreateAccessToken(database.AccessToken{
+			Id:        id,
+			UserId:    usr.Id,
+			Hash:      hashSecret(tokenStr),
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("unable to create access token: %s", err)
+		}
+		return tokenStr
+	}
+	readPAT := createPAT("read", nil, scopeChirpsRead)
+	writePAT := createPAT("write", nil, scopeChirpsRead, scopeChirpsWrite)
+	revokedPAT := createPAT("revoked", nil, scopeChirpsRead)
+	dbHandle.DeleteAccessToken(usr.Id, "revoked")
+	expired := time.Now().Add(-time.Minute)
+	expiredPAT := createPAT("expired", &expired, scopeChirpsRead)
+
+	login, _ := cfg.issueJWT(usr.Id, time.Hour, "", nil)
+	oauth, _ := cfg.issueJWT(usr.Id, time.Hour, "client", []string{scopeChirpsRead})
+	otherKey, _ := (&apiConfig{jwtSecret: "other"}).issueJWT(usr.Id, time.Hour, "", nil)
+
+	cases := []struct {
+		name           string
+		auth           string
+		scope          string
+		expectErr      bool
+		expectScopeErr bool
+		expectedScopes []string
+	}{
+		{name: "login", auth: "Bearer " + login, scope: scopeChirpsWrite},
+		{name: "login account", auth: "Bearer " + login, scope: scopeAccount},
+		{name: "oauth", auth: "Bearer " + oauth, scope: scopeChirpsRead, expectedScopes: []string{scopeChirpsRead}},
+		{name: "oauth missing scope", auth: "Bearer " + oauth, scope: scopeChirpsWrite, expectScopeErr: true},
+		{name: "oauth account", auth: "Bearer " + oauth, scope: scopeAccount, expectScopeErr: true},
+		{name: "pat", auth: "Bearer " + writePAT, scope: scopeChirpsWrite, expectedScopes: []string{scopeChirpsRead, scopeChirpsWrite}},
+		{name: "pat missing scope", auth: "Bearer " + readPAT, scope: scopeChirpsWrite, expectScopeErr: true},
+		{name: "pat account", auth: "Bearer " + writePAT, scope: scopeAccount, expectScopeErr: true},
+		{name: "revoked pat", auth: "Bearer " + revokedPAT, scope: scopeChirpsRead, expectErr: true},
+		{name: "expired pat", auth: "Bearer " + expiredPAT, scope: scopeChirpsRead, expectErr: true},
+		{name: "unknown pat", auth: "Bearer " + accessTokenPrefix + "unknown", scope: scopeChirpsRead, expectErr: true},
+		{name: "wrong signing key", auth: "Bearer " + otherKey, scope: scopeChirpsRead, expectErr: true},
+		{name: "wrong scheme", auth: "Basic " + login, scope: scopeChirpsRead, expectErr: true},
+		{name: "missing", auth: "", scope: scopeChirpsRead, expectErr: true},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/api/chirps", nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		info, err := cfg.authorize(req, c.scope)
+
+		if c.expectErr || c.expectScopeErr {
+			if err == nil {
+				t.Errorf("%s: authorize(%s) succeeded, expected an error", c.name, c.scope)
+			} else if errors.Is(err, errInsufficientScope) != c.expectScopeErr {
+				t.Errorf("%s: authorize(%s) error == %v, expected scope error: %v", c.name, c.scope, err, c.expectScopeErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: authorize(%s) error == %v", c.name, c.scope, err)
+			continue
+		}
+		if info.UserId != usr.Id {
+			t.Errorf("%s: user id == %d, expected %d", c.name, info.UserId, usr.Id)
+		}
+		if !slices.Equal(info.Scopes, c.expectedScopes) || (info.Scopes == nil) != (c.expectedScopes == nil) {
+			t.Errorf("%s: scopes == %v, expected %v", c.name, info.Scopes, c.expectedScopes)
+		}
+	}
+}
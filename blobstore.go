@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var errBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps the bytes of uploaded media, keys are generated by the
+// server and never come from clients
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (io.ReadSeekCloser, error)
+	Delete(key string) error
+}
+
+var blobKeyRegex = regexp.MustCompile(`^[a-z0-9_.-]+$`)
+
+// localBlobStore keeps blobs as files in a directory
+type localBlobStore struct {
+	root string
+}
+
+func newLocalBlobStore(root string) (*localBlobStore, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &localBlobStore{root: root}, nil
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	if !blobKeyRegex.MatchString(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, key), nil
+}
+
+// Put writes to a temporary file first so readers never see a partial blob
+func (s *localBlobStore) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.root, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localBlobStore) Get(key string) (io.ReadSeekCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return f, err
+}
+
+func (s *localBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
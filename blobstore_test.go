@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalBlobStore(t *testing.T) {
+	store, err := newLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("unable to create blob store: %s", err)
+	}
+
+	cases := []struct {
+		key       string
+		expectErr bool
+	}{
+		{key: "abc123.png"},
+		{key: "../escape.png", expectErr: true},
+		{key: "nested/key.png", expectErr: true},
+		{key: "", expectErr: true},
+	}
+
+	for _, c := range cases {
+		err := store.Put(c.key, strings.NewReader("data"))
+		if (err != nil) != c.expectErr {
+			t.Errorf("Put(%q) error == %v, expected an error: %v", c.key, err, c.expectErr)
+			continue
+		}
+		if c.expectErr {
+			continue
+		}
+		blob, err := store.Get(c.key)
+		if err != nil {
+			t.Errorf("Get(%q) error == %v", c.key, err)
+			continue
+		}
+		data, _ := io.ReadAll(blob)
+		blob.Close()
+		if string(data) != "data" {
+			t.Errorf("Get(%q) == %q, expected %q", c.key, data, "data")
+		}
+		store.Delete(c.key)
+		if _, err := store.Get(c.key); !errors.Is(err, errBlobNotFound) {
+			t.Errorf("Get(%q) after delete error == %v, expected %v", c.key, err, errBlobNotFound)
+		}
+	}
+}
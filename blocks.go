@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func (cfg *apiConfig) blockHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.setRestriction(w, r, (*database.DB).BlockUser)
+}
+
+func (cfg *apiConfig) unblockHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.setRestriction(w, r, (*database.DB).UnblockUser)
+}
+
+func (cfg *apiConfig) muteHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.setRestriction(w, r, (*database.DB).MuteUser)
+}
+
+func (cfg *apiConfig) unmuteHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.setRestriction(w, r, (*database.DB).UnmuteUser)
+}
+
+func (cfg *apiConfig) setRestriction(w http.ResponseWriter, r *http.Request, apply func(db *database.DB, usrId int, targetId int) error) {
+	info, err := cfg.authorize(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	targetId, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+	if targetId == info.UserId {
+		respondWithError(w, 400, "You can't block or mute yourself")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	err = apply(dbHandle, info.UserId, targetId)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	respondWithJSON(w, 204, "")
+}
+
+func (cfg *apiConfig) listBlocksHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.listRestrictions(w, r, (*database.DB).GetBlocks)
+}
+
+func (cfg *apiConfig) listMutesHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.listRestrictions(w, r, (*database.DB).GetMutes)
+}
+
+// listRestrictions responds with the users the caller has blocked or muted
+func (cfg *apiConfig) listRestrictions(w http.ResponseWriter, r *http.Request, load func(db *database.DB) ([]database.Restriction, error)) {
+	info, err := cfg.authorize(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	restrictions, err := load(dbHandle)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	allUsers, err := dbHandle.GetUsers()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	usersById := map[int]database.User{}
+	for _, usr := range allUsers {
+		usersById[usr.Id] = usr
+	}
+
+	users := []*authorSummary{}
+	for _, restriction := range restrictions {
+		if restriction.UserId != info.UserId {
+			continue
+		}
+		if target, ok := usersById[restriction.TargetId]; ok {
+			users = append(users, newAuthorSummary(target))
+		}
+	}
+	respondWithJSON(w, 200, users)
+}
+
+// viewerRestrictions are the users whose chirps a viewer shouldn't see
+type viewerRestrictions struct {
+	blocked map[int]bool
+	muted   map[int]bool
+}
+
+// loadViewerRestrictions collects everyone in a block with the viewer, in either
+// direction, and everyone the viewer has muted
+func loadViewerRestrictions(dbHandle *database.DB, viewerId int) (viewerRestrictions, error) {
+	restrictions := viewerRestrictions{blocked: map[int]bool{}, muted: map[int]bool{}}
+	if viewerId == 0 {
+		return restrictions, nil
+	}
+	blocks, err := dbHandle.GetBlocks()
+	if err != nil {
+		return viewerRestrictions{}, err
+	}
+	for _, block := range blocks {
+		if block.UserId == viewerId {
+			restrictions.blocked[block.TargetId] = true
+		}
+		if block.TargetId == viewerId {
+			restrictions.blocked[block.UserId] = true
+		}
+	}
+	mutes, err := dbHandle.GetMutes()
+	if err != nil {
+		return viewerRestrictions{}, err
+	}
+	for _, mute := range mutes {
+		if mute.UserId == viewerId {
+			restrictions.muted[mute.TargetId] = true
+		}
+	}
+	return restrictions, nil
+}
+
+// hides reports whether chirps by author are hidden, mutes only
+// apply to timelines
+func (v viewerRestrictions) hides(author int, timeline bool) bool {
+	return v.blocked[author] || (timeline && v.muted[author])
+}
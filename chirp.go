@@ -2,53 +2,23 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"strconv"
-	"strings"
+	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	database "github.com/zsolomon88/bootdev-chirpy/internal"
 )
 
 func (cfg *apiConfig) deleteHandle(w http.ResponseWriter, r *http.Request) {
-	authToken := r.Header.Get("Authorization")
-	tokenParts := strings.Split(authToken, " ")
-
-	if len(tokenParts) != 2 {
-		respondWithError(w, 403, "Invalid token recieved")
-		return
-	}
-	if tokenParts[0] != "Bearer" {
-		respondWithError(w, 403, "Invalid token type")
-		return
-	}
-
-	tokenStr := tokenParts[1]
-
-	tokenClaims := &jwt.RegisteredClaims{}
-	plainToken, err := jwt.ParseWithClaims(tokenStr, tokenClaims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return []byte(cfg.jwtSecret), nil
-	})
-	if err != nil {
-		respondWithError(w, 403, fmt.Sprintf("Incorrect token: %s", err))
-		return
-	}
-	usrId, err := plainToken.Claims.GetSubject()
-	if err != nil {
-		respondWithError(w, 403, "Incorrect token")
-		return
-	}
-	authorToDelete, err := strconv.Atoi(usrId)
+	info, err := cfg.authorize(r, scopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, 403, "Invalid user id")
+		respondWithAuthError(w, err, 403)
 		return
 	}
+	authorToDelete := info.UserId
 
 	chirpId := r.PathValue("chirpId")
 	idToDelete, err := strconv.Atoi(chirpId)
@@ -71,6 +41,7 @@ func (cfg *apiConfig) deleteHandle(w http.ResponseWriter, r *http.Request) {
 		if chirp.Id == idToDelete && chirp.Author == authorToDelete {
 			err = dbHandle.DeleteChirp(idToDelete)
 			if err == nil {
+				recordAudit(r, authorToDelete, auditChirpDelete, fmt.Sprintf("chirp:%d", idToDelete), outcomeSuccess)
 				respondWithJSON(w, 204, "")
 				return
 			} else {
@@ -80,82 +51,222 @@ func (cfg *apiConfig) deleteHandle(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	recordAudit(r, authorToDelete, auditChirpDelete, fmt.Sprintf("chirp:%d", idToDelete), outcomeDenied)
 	respondWithError(w, 403, "Chirp not found")
 }
 
-func (cfg *apiConfig) createHandle(w http.ResponseWriter, r *http.Request) {
-	authToken := r.Header.Get("Authorization")
-	tokenParts := strings.Split(authToken, " ")
+// chirpDraft is the content of a chirp being composed, scheduled chirps are
+// written the same way
+type chirpDraft struct {
+	Body     string   `json:"body"`
+	ReplyTo  int      `json:"reply_to_id"`
+	QuoteOf  int      `json:"quote_of_id"`
+	MediaIds []string `json:"media_ids"`
+}
 
-	if len(tokenParts) != 2 {
-		respondWithError(w, 403, "Invalid token recieved")
+func (cfg *apiConfig) createHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
 		return
 	}
-	if tokenParts[0] != "Bearer" {
-		respondWithError(w, 403, "Invalid token type")
-		return
+	type parameters struct {
+		chirpDraft
+		RechirpOf int `json:"rechirp_of_id"`
+		// PublishAt schedules the chirp instead of publishing it now
+		PublishAt *time.Time `json:"publish_at"`
 	}
 
-	tokenStr := tokenParts[1]
-
-	tokenClaims := &jwt.RegisteredClaims{}
-	plainToken, err := jwt.ParseWithClaims(tokenStr, tokenClaims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if params.RechirpOf != 0 {
+		if normalizeChirpBody(params.Body) != "" || params.ReplyTo != 0 || params.QuoteOf != 0 || len(params.MediaIds) > 0 {
+			respondWithError(w, 400, "A rechirp can't have a body, quote the chirp instead")
+			return
 		}
+		if params.PublishAt != nil {
+			respondWithError(w, 400, "Rechirps can't be scheduled")
+			return
+		}
+		cfg.rechirp(w, info.UserId, params.RechirpOf)
+		return
+	}
+	if params.PublishAt != nil {
+		cfg.scheduleChirp(w, info.UserId, params.chirpDraft, *params.PublishAt)
+		return
+	}
 
-		return []byte(cfg.jwtSecret), nil
-	})
+	dbHandle, err := database.NewDB("./database.json")
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("Incorrect token: %s", err))
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	draft, ok := cfg.composeChirp(w, dbHandle, info.UserId, params.chirpDraft)
+	if !ok {
+		return
+	}
+	chirp, err := dbHandle.InsertChirp(draft)
+	if errors.Is(err, database.ErrInvalidMedia) {
+		respondWithError(w, 400, "Attachments must be your own uploads that aren't attached to another chirp")
 		return
 	}
-	usrId, err := plainToken.Claims.GetSubject()
 	if err != nil {
-		respondWithError(w, 401, "Incorrect token")
+		respondWithError(w, 500, "Unable to write to database")
 		return
 	}
-	type parameters struct {
-		Body string `json:"body"`
+	respondWithChirp(w, 201, dbHandle, chirp, info.UserId)
+}
+
+// composeChirp validates a draft and turns it into a chirp ready to be
+// stored. It responds with an error and returns false when the draft can't
+// be posted.
+func (cfg *apiConfig) composeChirp(w http.ResponseWriter, dbHandle *database.DB, usrId int, draft chirpDraft) (database.Chirp, bool) {
+	chirpBody := normalizeChirpBody(draft.Body)
+	if chirpBody == "" && len(draft.MediaIds) == 0 {
+		respondWithError(w, 400, "Chirp is empty")
+		return database.Chirp{}, false
+	}
+	// duplicates are rejected before they count against the limit
+	attached := map[string]bool{}
+	for _, id := range draft.MediaIds {
+		if attached[id] {
+			respondWithError(w, 400, "Each attachment can only be added once")
+			return database.Chirp{}, false
+		}
+		attached[id] = true
+	}
+	if len(draft.MediaIds) > database.MaxChirpMedia {
+		respondWithError(w, 400, fmt.Sprintf("A chirp can have at most %d attachments", database.MaxChirpMedia))
+		return database.Chirp{}, false
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	author, err := dbHandle.GetUser(usrId)
 	if err != nil {
-		respondWithError(w, 500, "Something went wrong")
-		return
+		respondWithError(w, 401, "User not found")
+		return database.Chirp{}, false
 	}
-	chirpBody := strings.TrimSpace(params.Body)
-	if len(chirpBody) > 140 {
-		respondWithError(w, 400, "Chirp is too long")
-		return
+	if draft.ReplyTo != 0 {
+		parent, err := dbHandle.GetChirp(draft.ReplyTo)
+		if err != nil {
+			respondWithError(w, 404, "Chirp being replied to not found")
+			return database.Chirp{}, false
+		}
+		if !checkNotBlocked(w, dbHandle, usrId, parent.Author) {
+			return database.Chirp{}, false
+		}
 	}
-	for _, badWord := range getBadWords() {
-		chirpBody = replaceWord(chirpBody, badWord, "****")
+	if draft.QuoteOf != 0 {
+		quoted, err := dbHandle.GetChirp(draft.QuoteOf)
+		if err != nil {
+			respondWithError(w, 404, "Chirp being quoted not found")
+			return database.Chirp{}, false
+		}
+		// quoting a rechirp quotes what was rechirped
+		if quoted.RechirpOf != 0 {
+			draft.QuoteOf = quoted.RechirpOf
+			quoted, err = dbHandle.GetChirp(quoted.RechirpOf)
+			if err != nil {
+				respondWithError(w, 404, "Chirp being quoted not found")
+				return database.Chirp{}, false
+			}
+		}
+		if !checkNotBlocked(w, dbHandle, usrId, quoted.Author) {
+			return database.Chirp{}, false
+		}
 	}
-	type validReturn struct {
-		CleanBody string `json:"cleaned_body"`
+	filtered, err := cfg.checkChirpBody(author, chirpBody)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return database.Chirp{}, false
 	}
-	validBody := validReturn{
-		CleanBody: chirpBody,
+	mentions, err := resolveMentions(dbHandle, usrId, filtered.Body)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return database.Chirp{}, false
 	}
+	return database.Chirp{
+		Body:       filtered.Body,
+		Author:     usrId,
+		Moderation: filtered.moderation(),
+		ReplyTo:    draft.ReplyTo,
+		QuoteOf:    draft.QuoteOf,
+		Tags:       extractHashtags(filtered.Body),
+		Mentions:   mentions,
+		MediaIds:   draft.MediaIds,
+	}, true
+}
 
+// rechirp reposts a chirp as-is, rechirping a rechirp reposts the original
+func (cfg *apiConfig) rechirp(w http.ResponseWriter, usrId int, chirpId int) {
 	dbHandle, err := database.NewDB("./database.json")
 	if err != nil {
 		respondWithError(w, 500, "Unable to connect to database")
 		return
 	}
-	authorId, _ := strconv.Atoi(usrId)
-	chirp, err := dbHandle.CreateChirp(validBody.CleanBody, authorId)
+	original, err := dbHandle.GetChirp(chirpId)
 	if err != nil {
-		respondWithError(w, 500, "Unable to write to database")
+		respondWithError(w, 404, "Chirp being rechirped not found")
+		return
+	}
+	if original.RechirpOf != 0 {
+		chirpId = original.RechirpOf
+		original, err = dbHandle.GetChirp(chirpId)
+		if err != nil {
+			respondWithError(w, 404, "Chirp being rechirped not found")
+			return
+		}
+	}
+	if !checkNotBlocked(w, dbHandle, usrId, original.Author) {
+		return
+	}
+
+	chirp, err := dbHandle.InsertChirp(database.Chirp{Author: usrId, RechirpOf: chirpId})
+	if errors.Is(err, database.ErrAlreadyRechirped) {
+		respondWithError(w, 409, "You already rechirped this chirp")
 		return
 	}
-	respondWithJSON(w, 201, chirp)
+	if err != nil {
+		respondWithError(w, 404, "Chirp being rechirped not found")
+		return
+	}
+	respondWithChirp(w, 201, dbHandle, chirp, usrId)
+}
+
+// checkNotBlocked responds with 403 and returns false when either user
+// has blocked the other
+func checkNotBlocked(w http.ResponseWriter, dbHandle *database.DB, usrId int, otherId int) bool {
+	blocked, err := dbHandle.IsBlocked(usrId, otherId)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return false
+	}
+	if blocked {
+		respondWithError(w, 403, "You can't interact with this user")
+		return false
+	}
+	return true
 }
 
-func getHandle(w http.ResponseWriter, r *http.Request) {
+// checkChirpBody enforces the author's length limit on a normalized body
+// and runs it through the content filters
+func (cfg *apiConfig) checkChirpBody(author database.User, chirpBody string) (FilterResult, error) {
+	if maxLength := cfg.chirpLimits.forUser(author.RedStatus); chirpLength(chirpBody) > maxLength {
+		return FilterResult{}, fmt.Errorf("Chirp is too long, the limit is %d characters", maxLength)
+	}
+
+	filtered := cfg.contentFilters.run(chirpBody)
+	if filtered.Rejected {
+		return FilterResult{}, fmt.Errorf("Chirp rejected by content filter")
+	}
+	return filtered, nil
+}
+
+func (cfg *apiConfig) getHandle(w http.ResponseWriter, r *http.Request) {
 	dbHandle, err := database.NewDB("./database.json")
 	if err != nil {
 		respondWithError(w, 500, "Unable to connect to database")
@@ -190,20 +301,191 @@ func getHandle(w http.ResponseWriter, r *http.Request) {
 		chirps = filterAuthor
 	}
 	chirpId := r.PathValue("chirpId")
-	if chirpId == "" {
-		respondWithJSON(w, 200, chirps)
-	} else {
+	if chirpId != "" {
 		id, err := strconv.Atoi(chirpId)
 		if err != nil {
 			respondWithError(w, 500, "Issue getting chirp id")
 			return
 		}
+		found := []database.Chirp{}
 		for _, chirp := range chirps {
 			if chirp.Id == id {
-				respondWithJSON(w, 200, chirp)
-				return
+				found = append(found, chirp)
+				break
+			}
+		}
+		if len(found) == 0 {
+			respondWithError(w, 404, "Chrip not found")
+			return
+		}
+		chirps = found
+	}
+
+	view := cfg.chirpViewFor(r)
+	view.Timeline = chirpId == "" && authorToGet == ""
+	resp, err := buildChirpResponses(dbHandle, chirps, view)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	if chirpId != "" {
+		if len(resp) == 0 {
+			respondWithError(w, 404, "Chrip not found")
+			return
+		}
+		respondWithJSON(w, 200, resp[0])
+		return
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+type chirpResponse struct {
+	database.Chirp
+	AuthorInfo *authorSummary `json:"author,omitempty"`
+	ReplyCount int            `json:"reply_count"`
+	LikeCount  int            `json:"like_count"`
+	LikedByMe  bool           `json:"liked_by_me"`
+	// RechirpCount and QuoteCount count plain and quoted reposts
+	RechirpCount int `json:"rechirp_count"`
+	QuoteCount   int `json:"quote_count"`
+	// Original is the rechirped or quoted chirp, it is left out
+	// when a quoted chirp has since been deleted
+	Original *chirpResponse  `json:"original,omitempty"`
+	Media    []mediaResponse `json:"media,omitempty"`
+	// Moderation shadows the field on the embedded chirp so filter
+	// results stay internal to moderators
+	Moderation *struct{} `json:"moderation,omitempty"`
+}
+
+// respondWithChirp responds with a chirp the author just wrote, shaped the
+// same way reading it back would return it
+func respondWithChirp(w http.ResponseWriter, code int, dbHandle *database.DB, chirp database.Chirp, viewerId int) {
+	resp, err := buildChirpResponses(dbHandle, []database.Chirp{chirp}, chirpView{ViewerId: viewerId})
+	if err != nil || len(resp) == 0 {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, code, resp[0])
+}
+
+// chirpView describes who is reading chirps and how they want them rendered
+type chirpView struct {
+	ExpandAuthor bool
+	// ViewerId is 0 for anonymous readers
+	ViewerId int
+	// Timeline is set for feeds, where the viewer's mutes apply on top of blocks
+	Timeline bool
+}
+
+func (cfg *apiConfig) chirpViewFor(r *http.Request) chirpView {
+	return chirpView{
+		ExpandAuthor: r.URL.Query().Get("expand") == "author",
+		ViewerId:     cfg.viewerId(r),
+	}
+}
+
+// buildChirpResponses decorates chirps for the API with reply, like and repost
+// counts and embeds the original of rechirps and quotes, optionally embedding
+// a compact author object so clients don't have to resolve author ids.
+// Chirps the viewer has blocked or muted are left out of the result.
+func buildChirpResponses(dbHandle *database.DB, chirps []database.Chirp, view chirpView) ([]chirpResponse, error) {
+	restrictions, err := loadViewerRestrictions(dbHandle, view.ViewerId)
+	if err != nil {
+		return nil, err
+	}
+
+	allChirps, err := dbHandle.GetChirps()
+	if err != nil {
+		return nil, err
+	}
+	chirpsById := map[int]database.Chirp{}
+	replyCounts := map[int]int{}
+	rechirpCounts := map[int]int{}
+	quoteCounts := map[int]int{}
+	for _, chirp := range allChirps {
+		chirpsById[chirp.Id] = chirp
+		if chirp.ReplyTo != 0 {
+			replyCounts[chirp.ReplyTo]++
+		}
+		if chirp.RechirpOf != 0 {
+			rechirpCounts[chirp.RechirpOf]++
+		}
+		if chirp.QuoteOf != 0 {
+			quoteCounts[chirp.QuoteOf]++
+		}
+	}
+
+	likes, err := dbHandle.GetLikes()
+	if err != nil {
+		return nil, err
+	}
+	likeCounts := map[int]int{}
+	likedByViewer := map[int]bool{}
+	for _, like := range likes {
+		likeCounts[like.ChirpId]++
+		if view.ViewerId != 0 && like.UserId == view.ViewerId {
+			likedByViewer[like.ChirpId] = true
+		}
+	}
+
+	allMedia, err := dbHandle.GetAllMedia()
+	if err != nil {
+		return nil, err
+	}
+
+	authors := map[int]database.User{}
+	if view.ExpandAuthor {
+		users, err := dbHandle.GetUsers()
+		if err != nil {
+			return nil, err
+		}
+		for _, usr := range users {
+			authors[usr.Id] = usr
+		}
+	}
+
+	decorate := func(chirp database.Chirp) chirpResponse {
+		item := chirpResponse{
+			Chirp:        chirp,
+			ReplyCount:   replyCounts[chirp.Id],
+			LikeCount:    likeCounts[chirp.Id],
+			LikedByMe:    likedByViewer[chirp.Id],
+			RechirpCount: rechirpCounts[chirp.Id],
+			QuoteCount:   quoteCounts[chirp.Id],
+		}
+		if author, ok := authors[chirp.Author]; ok {
+			item.AuthorInfo = newAuthorSummary(author)
+		}
+		for _, id := range chirp.MediaIds {
+			if media, ok := allMedia[id]; ok {
+				item.Media = append(item.Media, newMediaResponse(media))
 			}
 		}
-		respondWithError(w, 404, "Chrip not found")
+		return item
+	}
+
+	resp := make([]chirpResponse, 0, len(chirps))
+	for _, chirp := range chirps {
+		if restrictions.hides(chirp.Author, view.Timeline) {
+			continue
+		}
+		item := decorate(chirp)
+		originalId := chirp.RechirpOf
+		if originalId == 0 {
+			originalId = chirp.QuoteOf
+		}
+		original, ok := chirpsById[originalId]
+		if ok && restrictions.hides(original.Author, view.Timeline) {
+			if chirp.RechirpOf != 0 {
+				continue
+			}
+			ok = false
+		}
+		if ok {
+			embedded := decorate(original)
+			item.Original = &embedded
+		}
+		resp = append(resp, item)
 	}
+	return resp, nil
 }
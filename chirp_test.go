@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+// newChirpConfig returns a config whose content filter masks "fornax",
+// flags "sharbert" and rejects "spam"
+func newChirpConfig(t *testing.T) *apiConfig {
+	configPath := t.TempDir() + "/filters.json"
+	err := os.WriteFile(configPath, []byte(`{"rules": [
+		{"word": "fornax", "action": "mask"},
+		{"word": "sharbert", "action": "flag"},
+		{"word": "spam", "action": "reject"}
+	]}`), 0644)
+	if err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+	filter, err := newWordListFilter(configPath)
+	if err != nil {
+		t.Fatalf("unable to load filter: %v", err)
+	}
+	return &apiConfig{
+		jwtSecret:      "secret",
+		wordFilter:     filter,
+		contentFilters: filterChain{filter},
+		chirpLimits:    chirpLimits{Default: 20, Red: 40},
+		editWindow:     15 * time.Minute,
+	}
+}
+
+func TestCreateHandle(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := newChirpConfig(t)
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	original, _ := dbHandle.CreateChirp("original", usr.Id)
+	dbHandle.CreateMedia(database.Media{Id: "media", OwnerId: usr.Id})
+	auth := bearer(t, cfg, usr.Id)
+
+	cases := []struct {
+		name         string
+		body         string
+		expected     int
+		expectedBody string
+	}{
+		{name: "plain", body: `{"body":"hello"}`, expected: 201, expectedBody: "hello"},
+		{name: "masked", body: `{"body":"a fornax"}`, expected: 201, expectedBody: "a ****"},
+		{name: "flagged", body: `{"body":"a sharbert"}`, expected: 201, expectedBody: "a sharbert"},
+		{name: "rejected", body: `{"body":"buy spam"}`, expected: 400},
+		{name: "too long", body: `{"body":"` + strings.Repeat("a", 21) + `"}`, expected: 400},
+		{name: "duplicate media", body: `{"media_ids":["media","media"]}`, expected: 400},
+		{name: "media", body: `{"media_ids":["media"]}`, expected: 201},
+		{name: "rechirp", body: fmt.Sprintf(`{"rechirp_of_id":%d}`, original.Id), expected: 201},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(c.body))
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		cfg.createHandle(rec, req)
+
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d: %s", c.name, rec.Code, c.expected, rec.Body.String())
+			continue
+		}
+		if c.expected != 201 {
+			continue
+		}
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(rec.Body.Bytes(), &fields)
+		if _, ok := fields["moderation"]; ok {
+			t.Errorf("%s: response exposes moderation: %s", c.name, rec.Body.String())
+		}
+		if _, ok := fields["like_count"]; !ok {
+			t.Errorf("%s: response isn't a chirp response: %s", c.name, rec.Body.String())
+		}
+		if c.expectedBody != "" && string(fields["body"]) != `"`+c.expectedBody+`"` {
+			t.Errorf("%s: body == %s, expected %q", c.name, fields["body"], c.expectedBody)
+		}
+	}
+
+	// the flag is still recorded for moderators
+	chirps, _ := dbHandle.GetChirps()
+	flagged := 0
+	for _, chirp := range chirps {
+		if chirp.Moderation != nil && chirp.Moderation.Flagged {
+			flagged++
+		}
+	}
+	if flagged != 1 {
+		t.Errorf("flagged chirp count == %d, expected 1", flagged)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+type exportProfile struct {
+	Id          int    `json:"id"`
+	Email       string `json:"email"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	RedStatus   bool   `json:"is_chirpy_red"`
+	Role        string `json:"role"`
+}
+
+// exportSession describes a login without any part of its refresh token
+type exportSession struct {
+	CreatedAt  time.Time `json:"created_at"`
+	Expiration time.Time `json:"expiration"`
+}
+
+type exportAccessToken struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type exportOAuthClient struct {
+	Id           string    `json:"id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// exportOAuthGrant is access the user gave a client, either a refresh token
+// the client holds or an authorization code it hasn't exchanged yet
+type exportOAuthGrant struct {
+	ClientId   string     `json:"client_id"`
+	Scopes     []string   `json:"scopes"`
+	Pending    bool       `json:"pending"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	Expiration time.Time  `json:"expiration"`
+}
+
+type exportFollows struct {
+	Following []database.Follow `json:"following"`
+	Followers []database.Follow `json:"followers"`
+}
+
+type userExport struct {
+	GeneratedAt        time.Time                        `json:"generated_at"`
+	Profile            exportProfile                    `json:"profile"`
+	Chirps             []database.Chirp                 `json:"chirps"`
+	Revisions          map[int][]database.ChirpRevision `json:"revisions"`
+	ScheduledChirps    []database.ScheduledChirp        `json:"scheduled_chirps"`
+	Likes              []database.Like                  `json:"likes"`
+	Follows            exportFollows                    `json:"follows"`
+	Blocks             []database.Restriction           `json:"blocks"`
+	Mutes              []database.Restriction           `json:"mutes"`
+	Notifications      []database.Notification          `json:"notifications"`
+	Media              []database.Media                 `json:"media"`
+	Sessions           []exportSession                  `json:"sessions"`
+	AccessTokens       []exportAccessToken              `json:"access_tokens"`
+	OAuthClients       []exportOAuthClient              `json:"oauth_clients"`
+	OAuthGrants        []exportOAuthGrant               `json:"oauth_grants"`
+	SubscriptionEvents []database.SubscriptionEvent     `json:"subscription_events"`
+	RoleChanges        []database.RoleChange            `json:"role_changes"`
+	// AuditLog only holds the events the user performed, events where they
+	// are just the target carry someone else's IP and user agent
+	AuditLog []database.AuditEntry `json:"audit_log"`
+}
+
+var exportIndexTemplate = template.Must(template.New("index").Parse(`<html>
+
+<body>
+    <h1>Chirpy data export for {{if .Profile.Handle}}@{{.Profile.Handle}}{{else}}{{.Profile.Email}}{{end}}</h1>
+    <p>Generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}}. The same data, with every field, is in data.json.</p>
+
+    <h2>Profile</h2>
+    <ul>
+        <li>Id: {{.Profile.Id}}</li>
+        <li>Email: {{.Profile.Email}}</li>
+        <li>Handle: {{.Profile.Handle}}</li>
+        <li>Display name: {{.Profile.DisplayName}}</li>
+        <li>Bio: {{.Profile.Bio}}</li>
+        <li>Avatar: {{.Profile.AvatarURL}}</li>
+        <li>Chirpy Red: {{.Profile.RedStatus}}</li>
+        <li>Role: {{.Profile.Role}}</li>
+    </ul>
+
+    <h2>Chirps ({{len .Chirps}})</h2>
+    <ol>
+    {{range .Chirps}}    <li>#{{.Id}}: {{.Body}}</li>
+    {{end}}</ol>
+
+    <h2>Scheduled chirps ({{len .ScheduledChirps}})</h2>
+    <ul>
+    {{range .ScheduledChirps}}    <li>{{.PublishAt.Format "2006-01-02 15:04:05 MST"}} ({{.Status}}): {{.Chirp.Body}}</li>
+    {{end}}</ul>
+
+    <h2>Edited chirps ({{len .Revisions}})</h2>
+    <ul>
+    {{range $id, $revisions := .Revisions}}    <li>#{{$id}}: {{len $revisions}} earlier versions</li>
+    {{end}}</ul>
+
+    <h2>Likes ({{len .Likes}})</h2>
+    <ul>
+    {{range .Likes}}    <li>Chirp #{{.ChirpId}}</li>
+    {{end}}</ul>
+
+    <h2>Following ({{len .Follows.Following}})</h2>
+    <ul>
+    {{range .Follows.Following}}    <li>User #{{.FolloweeId}}</li>
+    {{end}}</ul>
+
+    <h2>Followers ({{len .Follows.Followers}})</h2>
+    <ul>
+    {{range .Follows.Followers}}    <li>User #{{.FollowerId}}</li>
+    {{end}}</ul>
+
+    <h2>Blocked users ({{len .Blocks}})</h2>
+    <ul>
+    {{range .Blocks}}    <li>User #{{.TargetId}}</li>
+    {{end}}</ul>
+
+    <h2>Muted users ({{len .Mutes}})</h2>
+    <ul>
+    {{range .Mutes}}    <li>User #{{.TargetId}}</li>
+    {{end}}</ul>
+
+    <h2>Notifications ({{len .Notifications}})</h2>
+    <ul>
+    {{range .Notifications}}    <li>{{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}: {{.Type}}</li>
+    {{end}}</ul>
+
+    <h2>Media ({{len .Media}})</h2>
+    <ul>
+    {{range .Media}}    <li>{{.Id}}{{if .AltText}}: {{.AltText}}{{end}}</li>
+    {{end}}</ul>
+
+    <h2>Sessions ({{len .Sessions}})</h2>
+    <ul>
+    {{range .Sessions}}    <li>Signed in {{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}, expires {{.Expiration.Format "2006-01-02 15:04:05 MST"}}</li>
+    {{end}}</ul>
+
+    <h2>Personal access tokens ({{len .AccessTokens}})</h2>
+    <ul>
+    {{range .AccessTokens}}    <li>{{.Name}} ({{.Id}})</li>
+    {{end}}</ul>
+
+    <h2>OAuth clients ({{len .OAuthClients}})</h2>
+    <ul>
+    {{range .OAuthClients}}    <li>{{.Name}} ({{.Id}})</li>
+    {{end}}</ul>
+
+    <h2>Apps with access ({{len .OAuthGrants}})</h2>
+    <ul>
+    {{range .OAuthGrants}}    <li>{{.ClientId}}, expires {{.Expiration.Format "2006-01-02 15:04:05 MST"}}</li>
+    {{end}}</ul>
+
+    <h2>Subscription events ({{len .SubscriptionEvents}})</h2>
+    <ul>
+    {{range .SubscriptionEvents}}    <li>{{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}: {{.Event}}</li>
+    {{end}}</ul>
+
+    <h2>Role changes ({{len .RoleChanges}})</h2>
+    <ul>
+    {{range .RoleChanges}}    <li>{{.CreatedAt.Format "2006-01-02 15:04:05 MST"}}: {{.OldRole}} to {{.NewRole}}</li>
+    {{end}}</ul>
+
+    <h2>Security events ({{len .AuditLog}})</h2>
+    <ul>
+    {{range .AuditLog}}    <li>{{.Time.Format "2006-01-02 15:04:05 MST"}}: {{.Action}} ({{.Outcome}}) from {{.IP}}</li>
+    {{end}}</ul>
+</body>
+
+</html>
+`))
+
+// buildUserExport collects everything stored about a user, leaving out
+// the password hash, token values and hashes, and client secrets
+func buildUserExport(dbHandle *database.DB, auditLog *database.AuditLog, usrId int) (userExport, error) {
+	data, err := dbHandle.GetUserData(usrId)
+	if err != nil {
+		return userExport{}, err
+	}
+	auditEntries, err := auditLog.Query(database.AuditFilter{ActorId: usrId})
+	if err != nil {
+		return userExport{}, err
+	}
+	usr := data.User
+
+	sessions := []exportSession{}
+	grants := []exportOAuthGrant{}
+	for _, token := range data.RefreshTokens {
+		if token.ClientId != "" {
+			grants = append(grants, exportOAuthGrant{
+				ClientId:   token.ClientId,
+				Scopes:     token.Scopes,
+				CreatedAt:  &token.CreatedAt,
+				Expiration: token.Expiration,
+			})
+			continue
+		}
+		sessions = append(sessions, exportSession{CreatedAt: token.CreatedAt, Expiration: token.Expiration})
+	}
+	for _, code := range data.AuthorizationCodes {
+		grants = append(grants, exportOAuthGrant{
+			ClientId:   code.ClientId,
+			Scopes:     code.Scopes,
+			Pending:    true,
+			Expiration: code.Expiration,
+		})
+	}
+
+	accessTokens := []exportAccessToken{}
+	for _, token := range data.AccessTokens {
+		accessTokens = append(accessTokens, exportAccessToken{
+			Id:        token.Id,
+			Name:      token.Name,
+			Scopes:    token.Scopes,
+			CreatedAt: token.CreatedAt,
+			ExpiresAt: token.ExpiresAt,
+		})
+	}
+	clients := []exportOAuthClient{}
+	for _, client := range data.OAuthClients {
+		clients = append(clients, exportOAuthClient{
+			Id:           client.Id,
+			Name:         client.Name,
+			RedirectURIs: client.RedirectURIs,
+			CreatedAt:    client.CreatedAt,
+		})
+	}
+
+	return userExport{
+		GeneratedAt: time.Now().UTC(),
+		Profile: exportProfile{
+			Id:          usr.Id,
+			Email:       usr.Email,
+			Handle:      usr.Handle,
+			DisplayName: usr.DisplayName,
+			Bio:         usr.Bio,
+			AvatarURL:   usr.AvatarURL,
+			RedStatus:   usr.RedStatus,
+			Role:        usr.Role,
+		},
+		Chirps:             data.Chirps,
+		Revisions:          data.Revisions,
+		ScheduledChirps:    data.ScheduledChirps,
+		Likes:              data.Likes,
+		Follows:            exportFollows{Following: data.Following, Followers: data.Followers},
+		Blocks:             data.Blocks,
+		Mutes:              data.Mutes,
+		Notifications:      data.Notifications,
+		Media:              data.Media,
+		Sessions:           sessions,
+		AccessTokens:       accessTokens,
+		OAuthClients:       clients,
+		OAuthGrants:        grants,
+		SubscriptionEvents: data.SubscriptionEvents,
+		RoleChanges:        data.RoleChanges,
+		AuditLog:           auditEntries,
+	}, nil
+}
+
+// writeExportArchive zips the export as data.json plus a readable index.html
+func writeExportArchive(export userExport) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+
+	dataFile, err := archive.Create("data.json")
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(dataFile)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(export)
+	if err != nil {
+		return nil, err
+	}
+
+	indexFile, err := archive.Create("index.html")
+	if err != nil {
+		return nil, err
+	}
+	err = exportIndexTemplate.Execute(indexFile, export)
+	if err != nil {
+		return nil, err
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cfg *apiConfig) exportHandle(w http.ResponseWriter, r *http.Request) {
+	usrId, err := cfg.userIdFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+
+	auditLog, err := database.NewAuditLog("./audit.log")
+	if err != nil {
+		respondWithError(w, 500, "Unable to open audit log")
+		return
+	}
+
+	export, err := buildUserExport(dbHandle, auditLog, usrId)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	archive, err := writeExportArchive(export)
+	if err != nil {
+		respondWithError(w, 500, "Unable to create export")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%d.zip"`, usrId))
+	w.WriteHeader(200)
+	w.Write(archive)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+// exportedTables maps each table of the database to the key its rows are
+// exported under, derivedTables are built from other tables or hold nothing
+// about a particular user
+var (
+	exportedTables = map[string]string{
+		"Users":              "profile",
+		"Chirps":             "chirps",
+		"ChirpRevisions":     "revisions",
+		"ScheduledChirps":    "scheduled_chirps",
+		"Likes":              "likes",
+		"Follows":            "follows",
+		"Blocks":             "blocks",
+		"Mutes":              "mutes",
+		"Notifications":      "notifications",
+		"Media":              "media",
+		"RefreshTokens":      "sessions",
+		"AccessTokens":       "access_tokens",
+		"OAuthClients":       "oauth_clients",
+		"AuthorizationCodes": "oauth_grants",
+		"SubscriptionEvents": "subscription_events",
+		"RoleChanges":        "role_changes",
+	}
+	derivedTables = map[string]bool{
+		"Timelines":          true,
+		"TagCounts":          true,
+		"SearchIndex":        true,
+		"OrphanedBlobs":      true,
+		"LastUserId":         true,
+		"LastChirpId":        true,
+		"LastNotificationId": true,
+		"LastScheduledId":    true,
+	}
+)
+
+func TestUserExportCoversEveryTable(t *testing.T) {
+	structure := reflect.TypeOf(database.DBStructure{})
+	for i := 0; i < structure.NumField(); i++ {
+		name := structure.Field(i).Name
+		if _, ok := exportedTables[name]; !ok && !derivedTables[name] {
+			t.Errorf("table %s is neither exported nor listed as derived", name)
+		}
+	}
+
+	dir := t.TempDir()
+	db, err := database.NewDB(dir + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	auditLog, err := database.NewAuditLog(dir + "/audit.log")
+	if err != nil {
+		t.Fatalf("unable to create audit log: %s", err)
+	}
+	usr, _ := db.CreateUser("export@boot.dev", "pwd")
+	friend, _ := db.CreateUser("friend@boot.dev", "pwd")
+	blocked, _ := db.CreateUser("blocked@boot.dev", "pwd")
+	muted, _ := db.CreateUser("muted@boot.dev", "pwd")
+
+	chirp, _ := db.CreateChirp("first", usr.Id)
+	db.EditChirp(chirp.Id, usr.Id, database.Chirp{Body: "edited"}, time.Hour)
+	db.ScheduleChirp(database.ScheduledChirp{Chirp: database.Chirp{Body: "later", Author: usr.Id}, PublishAt: time.Now().Add(time.Hour)})
+	friendChirp, _ := db.CreateChirp("friend", friend.Id)
+	db.LikeChirp(usr.Id, friendChirp.Id)
+	db.FollowUser(usr.Id, friend.Id)
+	db.FollowUser(friend.Id, usr.Id)
+	db.BlockUser(usr.Id, blocked.Id)
+	db.MuteUser(usr.Id, muted.Id)
+	db.CreateMedia(database.Media{Id: "media", OwnerId: usr.Id})
+	session, _ := db.CreateRefreshToken(time.Now().Add(time.Hour), usr.Id)
+	grant, _ := db.CreateClientRefreshToken(time.Now().Add(time.Hour), usr.Id, "client", []string{scopeChirpsRead})
+	db.CreateAccessToken(database.AccessToken{Id: "pat", UserId: usr.Id, Name: "bot", Hash: "pat-hash"})
+	db.CreateOAuthClient(database.OAuthClient{Id: "client", OwnerId: usr.Id, Name: "app", SecretHash: "client-secret-hash"})
+	db.CreateAuthorizationCode(database.AuthorizationCode{Hash: "code-hash", ClientId: "client", UserId: usr.Id, Expiration: time.Now().Add(time.Minute)})
+	db.RecordSubscriptionEvent(usr.Id, "user.upgraded")
+	db.SetUserRole(usr.Id, usr.Id, database.RoleModerator)
+	auditLog.Append(database.AuditEntry{ActorId: usr.Id, Action: auditLogin, Target: userTarget(usr.Id), IP: "192.0.2.1", Outcome: outcomeSuccess})
+	// a failed login against the user comes from someone else's address
+	auditLog.Append(database.AuditEntry{Action: auditLogin, Target: userTarget(usr.Id), IP: "198.51.100.7", Outcome: outcomeFailure})
+
+	export, err := buildUserExport(db, auditLog, usr.Id)
+	if err != nil {
+		t.Fatalf("unable to build export: %s", err)
+	}
+	data, _ := json.Marshal(export)
+	fields := map[string]json.RawMessage{}
+	json.Unmarshal(data, &fields)
+
+	for table, key := range exportedTables {
+		value := string(fields[key])
+		if value == "" || value == "null" || value == "[]" || value == "{}" {
+			t.Errorf("table %s is missing from the export under %s", table, key)
+		}
+	}
+	if len(export.AuditLog) != 1 || export.AuditLog[0].IP != "192.0.2.1" {
+		t.Errorf("audit log == %+v, expected only the user's own login", export.AuditLog)
+	}
+	for _, secret := range []string{"pwd", session.Token[:8], grant.Token[:8], "pat-hash", "client-secret-hash", "code-hash"} {
+		if bytes.Contains(data, []byte(secret)) {
+			t.Errorf("export contains the secret %q", secret)
+		}
+	}
+}
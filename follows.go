@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+func (cfg *apiConfig) followHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollow(w, r, true)
+}
+
+func (cfg *apiConfig) unfollowHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollow(w, r, false)
+}
+
+func (cfg *apiConfig) setFollow(w http.ResponseWriter, r *http.Request, follow bool) {
+	info, err := cfg.authorize(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	followeeId, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+	if followeeId == info.UserId {
+		respondWithError(w, 400, "You can't follow yourself")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	if follow {
+		err = dbHandle.FollowUser(info.UserId, followeeId)
+	} else {
+		err = dbHandle.UnfollowUser(info.UserId, followeeId)
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, 403, "You can't follow this user")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	respondWithJSON(w, 204, "")
+}
+
+func followersHandle(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, func(follow database.Follow, usrId int) (int, bool) {
+		return follow.FollowerId, follow.FolloweeId == usrId
+	})
+}
+
+func followingHandle(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, func(follow database.Follow, usrId int) (int, bool) {
+		return follow.FolloweeId, follow.FollowerId == usrId
+	})
+}
+
+// userPage is one page of a list of users, the newest entries first
+type userPage struct {
+	Users []*authorSummary `json:"users"`
+	Total int              `json:"total"`
+	// NextOffset is passed as offset to fetch the next page, it is left
+	// out on the last page
+	NextOffset int `json:"next_offset,omitempty"`
+}
+
+// listFollows responds with a page of one side of a user's follows, pick
+// returns the user to list for a follow and whether the follow belongs in
+// the list
+func listFollows(w http.ResponseWriter, r *http.Request, pick func(follow database.Follow, usrId int) (int, bool)) {
+	usrId, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+	limit, offset, ok := offsetPageParams(r)
+	if !ok {
+		respondWithError(w, 400, "Invalid pagination parameters")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	if _, err := dbHandle.GetUser(usrId); err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	follows, err := dbHandle.GetFollows()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	allUsers, err := dbHandle.GetUsers()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	usersById := map[int]database.User{}
+	for _, usr := range allUsers {
+		usersById[usr.Id] = usr
+	}
+
+	// follows are stored in the order they were made, newest go first
+	listed := []database.Follow{}
+	for i := len(follows) - 1; i >= 0; i-- {
+		otherId, ok := pick(follows[i], usrId)
+		if _, exists := usersById[otherId]; ok && exists {
+			listed = append(listed, follows[i])
+		}
+	}
+
+	start := min(offset, len(listed))
+	end := start + min(limit, len(listed)-start)
+	page := userPage{Users: []*authorSummary{}, Total: len(listed)}
+	for _, follow := range listed[start:end] {
+		otherId, _ := pick(follow, usrId)
+		page.Users = append(page.Users, newAuthorSummary(usersById[otherId]))
+	}
+	if end < len(listed) {
+		page.NextOffset = end
+	}
+	respondWithJSON(w, 200, page)
+}
+
+// followCounts returns how many followers a user has and how many users they follow
+func followCounts(dbHandle *database.DB, usrId int) (int, int, error) {
+	follows, err := dbHandle.GetFollows()
+	if err != nil {
+		return 0, 0, err
+	}
+	followers, following := 0, 0
+	for _, follow := range follows {
+		if follow.FolloweeId == usrId {
+			followers++
+		}
+		if follow.FollowerId == usrId {
+			following++
+		}
+	}
+	return followers, following, nil
+}
+
+type chirpPage struct {
+	Chirps []chirpResponse `json:"chirps"`
+	// NextCursor is passed as before to fetch the next page, it is
+	// left out on the last page
+	NextCursor int `json:"next_cursor,omitempty"`
+}
+
+// fillChirpPage builds a page of up to limit chirps the viewer can see.
+// Blocks and mutes don't rewrite stored lists, so fetch is called again
+// from where the last call stopped until the page is full or nothing older
+// is left, instead of returning a page with the hidden chirps cut out.
+func fillChirpPage(dbHandle *database.DB, view chirpView, before int, limit int, fetch func(before int, limit int) ([]database.Chirp, int, error)) (chirpPage, error) {
+	page := chirpPage{Chirps: []chirpResponse{}}
+	for {
+		chirps, next, err := fetch(before, limit)
+		if err != nil {
+			return chirpPage{}, err
+		}
+		resp, err := buildChirpResponses(dbHandle, chirps, view)
+		if err != nil {
+			return chirpPage{}, err
+		}
+		page.Chirps = append(page.Chirps, resp...)
+		if len(page.Chirps) > limit {
+			page.Chirps = page.Chirps[:limit]
+			page.NextCursor = page.Chirps[limit-1].Id
+			return page, nil
+		}
+		if len(page.Chirps) == limit || next == 0 {
+			page.NextCursor = next
+			return page, nil
+		}
+		before = next
+	}
+}
+
+// offsetPageParams reads the limit and offset query parameters of lists
+// that have no stable id to use as a cursor
+func offsetPageParams(r *http.Request) (int, int, bool) {
+	limit := defaultPageSize
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			return 0, 0, false
+		}
+		limit = min(parsed, maxPageSize)
+	}
+	offset := 0
+	if raw := r.URL.Query().Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return 0, 0, false
+		}
+		offset = parsed
+	}
+	return limit, offset, true
+}
+
+// pageParams reads the limit and before query parameters shared by paginated endpoints
+func pageParams(r *http.Request) (int, int, bool) {
+	limit := defaultPageSize
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			return 0, 0, false
+		}
+		limit = min(parsed, maxPageSize)
+	}
+	before := 0
+	if raw := r.URL.Query().Get("before"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return 0, 0, false
+		}
+		before = parsed
+	}
+	return limit, before, true
+}
+
+// timelineHandle pages through the viewer's home timeline, which only keeps
+// the newest database.TimelineLimit chirps. The last page has no next
+// cursor even when older chirps exist, those are reached through the
+// authors' own chirps.
+func (cfg *apiConfig) timelineHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	limit, before, ok := pageParams(r)
+	if !ok {
+		respondWithError(w, 400, "Invalid pagination parameters")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	view := cfg.chirpViewFor(r)
+	view.ViewerId = info.UserId
+	view.Timeline = true
+	page, err := fillChirpPage(dbHandle, view, before, limit, func(before int, limit int) ([]database.Chirp, int, error) {
+		return dbHandle.GetTimeline(info.UserId, before, limit)
+	})
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 200, page)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestListFollowsPages(t *testing.T) {
+	dbHandle := useTempDB(t)
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	followers := []int{}
+	for i := 0; i < 5; i++ {
+		follower, _ := dbHandle.CreateUser(fmt.Sprintf("follower%d@boot.dev", i), "pwd")
+		dbHandle.FollowUser(follower.Id, usr.Id)
+		followers = append(followers, follower.Id)
+	}
+	// a deleted follower is left out of the list and the total
+	gone, _ := dbHandle.CreateUser("gone@boot.dev", "pwd")
+	dbHandle.FollowUser(gone.Id, usr.Id)
+	dbHandle.DeleteUser(gone.Id, database.AnonymizeChirps)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users/{userId}/followers", followersHandle)
+
+	cases := []struct {
+		query        string
+		expected     int
+		expectedIds  []int
+		expectedNext int
+	}{
+		{query: "limit=2", expected: 200, expectedIds: []int{followers[4], followers[3]}, expectedNext: 2},
+		{query: "limit=2&offset=2", expected: 200, expectedIds: []int{followers[2], followers[1]}, expectedNext: 4},
+		{query: "limit=2&offset=4", expected: 200, expectedIds: []int{followers[0]}},
+		{query: "offset=9223372036854775807", expected: 200, expectedIds: []int{}},
+		{query: "", expected: 200, expectedIds: []int{followers[4], followers[3], followers[2], followers[1], followers[0]}},
+		{query: "limit=0", expected: 400},
+		{query: "offset=-1", expected: 400},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/api/users/%d/followers?%s", usr.Id, c.query), nil))
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d", c.query, rec.Code, c.expected)
+			continue
+		}
+		if c.expected != 200 {
+			continue
+		}
+		page := struct {
+			Users []struct {
+				Id int `json:"id"`
+			} `json:"users"`
+			Total      int `json:"total"`
+			NextOffset int `json:"next_offset"`
+		}{}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		ids := []int{}
+		for _, listed := range page.Users {
+			ids = append(ids, listed.Id)
+		}
+		if !slices.Equal(ids, c.expectedIds) {
+			t.Errorf("%s: ids == %v, expected %v", c.query, ids, c.expectedIds)
+		}
+		if page.Total != len(followers) || page.NextOffset != c.expectedNext {
+			t.Errorf("%s: total == %d, next == %d, expected %d, %d", c.query, page.Total, page.NextOffset, len(followers), c.expectedNext)
+		}
+	}
+}
+
+func TestTimelineHandleSkipsMutedAuthors(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{jwtSecret: "secret"}
+	viewer, _ := dbHandle.CreateUser("viewer@boot.dev", "pwd")
+	author, _ := dbHandle.CreateUser("author@boot.dev", "pwd")
+	muted, _ := dbHandle.CreateUser("muted@boot.dev", "pwd")
+	dbHandle.FollowUser(viewer.Id, author.Id)
+	dbHandle.FollowUser(viewer.Id, muted.Id)
+	authored := []int{}
+	for i := 0; i < 3; i++ {
+		chirp, _ := dbHandle.CreateChirp(fmt.Sprintf("chirp %d", i), author.Id)
+		authored = append(authored, chirp.Id)
+	}
+	// the newest chirps are by an author muted after they were fanned out
+	for i := 0; i < 3; i++ {
+		dbHandle.CreateChirp(fmt.Sprintf("muted %d", i), muted.Id)
+	}
+	dbHandle.MuteUser(viewer.Id, muted.Id)
+	auth := bearer(t, cfg, viewer.Id)
+
+	cases := []struct {
+		query        string
+		expectedIds  []int
+		expectedNext int
+	}{
+		{query: "limit=2", expectedIds: []int{authored[2], authored[1]}, expectedNext: authored[1]},
+		{query: fmt.Sprintf("limit=2&before=%d", authored[1]), expectedIds: []int{authored[0]}},
+		{query: "limit=5", expectedIds: []int{authored[2], authored[1], authored[0]}},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/api/timeline?"+c.query, nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		cfg.timelineHandle(rec, req)
+		if rec.Code != 200 {
+			t.Errorf("%s: status == %d, expected 200", c.query, rec.Code)
+			continue
+		}
+
+		page := struct {
+			Chirps []struct {
+				Id int `json:"id"`
+			} `json:"chirps"`
+			NextCursor int `json:"next_cursor"`
+		}{}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		ids := []int{}
+		for _, chirp := range page.Chirps {
+			ids = append(ids, chirp.Id)
+		}
+		if !slices.Equal(ids, c.expectedIds) || page.NextCursor != c.expectedNext {
+			t.Errorf("%s: page == %v next %d, expected %v next %d", c.query, ids, page.NextCursor, c.expectedIds, c.expectedNext)
+		}
+	}
+}
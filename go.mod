@@ -5,5 +5,10 @@ go 1.22.3
 require (
 	github.com/golang-jwt/jwt/v5 v5.2.1
 	github.com/joho/godotenv v1.5.1
+	github.com/rivo/uniseg v0.4.7
 	golang.org/x/crypto v0.23.0
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.16.0
 )
+
+require golang.org/x/sys v0.20.0 // indirect
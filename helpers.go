@@ -4,13 +4,8 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"regexp"
 )
 
-func getBadWords() []string {
-	return []string{"kerfuffle", "sharbert", "fornax"}
-}
-
 func respondWithError(w http.ResponseWriter, code int, msg string) {
 	w.Header().Set("Content-Type", "applicatoin/json")
 	w.WriteHeader(code)
@@ -43,8 +38,3 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 
 	w.Write(dat)
 }
-
-func replaceWord(subject string, search string, replace string) string {
-	searchRegex := regexp.MustCompile("(?i)" + search)
-	return searchRegex.ReplaceAllString(subject, replace)
-}
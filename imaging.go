@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// maxMediaPixels keeps a small, highly compressed upload from
+	// decoding into gigabytes of memory
+	maxMediaPixels = 40_000_000
+	jpegQuality    = 85
+)
+
+// mediaVariant is a size uploads are re-encoded to, images are scaled down
+// to fit within MaxSize×MaxSize and never scaled up
+type mediaVariant struct {
+	Name    string
+	MaxSize int
+}
+
+var mediaVariants = []mediaVariant{
+	{Name: "thumb", MaxSize: 400},
+	{Name: "display", MaxSize: 1600},
+}
+
+// encodedImage is a variant ready to be stored, Key is derived from a hash
+// of the bytes so the blob never changes once it has a name
+type encodedImage struct {
+	Key         string
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// processImage turns an upload into its variants. Only the pixels survive:
+// the encoders write no metadata, so EXIF and GPS data are dropped once the
+// orientation it describes has been applied. Animated GIFs keep their
+// first frame.
+func processImage(data []byte) (map[string]encodedImage, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	orientation := jpegOrientation(data)
+
+	variants := map[string]encodedImage{}
+	for _, variant := range mediaVariants {
+		scaled := orientImage(scaleImage(img, variant.MaxSize), orientation)
+		encoded, err := encodeImage(scaled)
+		if err != nil {
+			return nil, err
+		}
+		variants[variant.Name] = encoded
+	}
+	return variants, nil
+}
+
+// fitWithin returns the size of a width×height image scaled down to fit
+// within maxSize×maxSize
+func fitWithin(width, height, maxSize int) (int, int) {
+	if width <= maxSize && height <= maxSize {
+		return width, height
+	}
+	if width >= height {
+		return maxSize, max(1, height*maxSize/width)
+	}
+	return max(1, width*maxSize/height), maxSize
+}
+
+func scaleImage(img image.Image, maxSize int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), maxSize)
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width == bounds.Dx() && height == bounds.Dy() {
+		draw.Draw(scaled, scaled.Bounds(), img, bounds.Min, draw.Src)
+		return scaled
+	}
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
+
+// encodeImage writes opaque images as JPEG and keeps PNG for anything with
+// transparency
+func encodeImage(img *image.RGBA) (encodedImage, error) {
+	buf := &bytes.Buffer{}
+	contentType, extension := "image/jpeg", ".jpg"
+	var err error
+	if img.Opaque() {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		contentType, extension = "image/png", ".png"
+		err = png.Encode(buf, img)
+	}
+	if err != nil {
+		return encodedImage{}, err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return encodedImage{
+		Key:         hex.EncodeToString(sum[:16]) + extension,
+		ContentType: contentType,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+// orientImage applies an EXIF orientation so the pixels are stored the way
+// they are meant to be displayed
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	if orientation >= 5 {
+		// 5 to 8 turn the image on its side
+		out = image.NewRGBA(image.Rect(0, 0, height, width))
+	}
+	bounds := out.Bounds()
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			var srcX, srcY int
+			switch orientation {
+			case 2: // mirrored
+				srcX, srcY = width-1-x, y
+			case 3: // rotated 180°
+				srcX, srcY = width-1-x, height-1-y
+			case 4: // mirrored vertically
+				srcX, srcY = x, height-1-y
+			case 5: // transposed
+				srcX, srcY = y, x
+			case 6: // needs rotating 90° clockwise
+				srcX, srcY = y, height-1-x
+			case 7: // transversed
+				srcX, srcY = width-1-y, height-1-x
+			case 8: // needs rotating 90° counterclockwise
+				srcX, srcY = width-1-y, x
+			}
+			out.SetRGBA(x, y, img.RGBAAt(srcX, srcY))
+		}
+	}
+	return out
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, 1 (upright) when
+// the data isn't a JPEG or has no orientation tag
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte before a marker
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// markers without a length
+			i += 2
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// metadata always comes before the image data
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation looks up the orientation tag in the first IFD of a TIFF
+// structure, which is how EXIF data is laid out
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+	ifd := int64(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > int64(len(tiff)) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := int(ifd) + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// exifJPEG encodes a width×height JPEG carrying an EXIF segment with the
+// given orientation and a GPS tag
+func exifJPEG(t *testing.T, width, height, orientation int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatalf("unable to encode jpeg: %s", err)
+	}
+
+	tiff := &bytes.Buffer{}
+	tiff.WriteString("MM")
+	binary.Write(tiff, binary.BigEndian, []uint16{42})
+	binary.Write(tiff, binary.BigEndian, []uint32{8})
+	binary.Write(tiff, binary.BigEndian, []uint16{2})
+	// orientation, SHORT, one value
+	binary.Write(tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(tiff, binary.BigEndian, []uint32{1})
+	binary.Write(tiff, binary.BigEndian, []uint16{uint16(orientation), 0})
+	// GPS IFD pointer, LONG, one value
+	binary.Write(tiff, binary.BigEndian, []uint16{0x8825, 4})
+	binary.Write(tiff, binary.BigEndian, []uint32{1, 0})
+	binary.Write(tiff, binary.BigEndian, []uint32{0})
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func TestJpegOrientation(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     []byte
+		expected int
+	}{
+		{name: "rotated", data: exifJPEG(t, 4, 2, 6), expected: 6},
+		{name: "upright", data: exifJPEG(t, 4, 2, 1), expected: 1},
+		{name: "out of range", data: exifJPEG(t, 4, 2, 9), expected: 1},
+		{name: "truncated", data: exifJPEG(t, 4, 2, 6)[:30], expected: 1},
+		{name: "not a jpeg", data: []byte("\x89PNG\r\n\x1a\n"), expected: 1},
+	}
+
+	for _, c := range cases {
+		if actual := jpegOrientation(c.data); actual != c.expected {
+			t.Errorf("%s: jpegOrientation() == %d, expected %d", c.name, actual, c.expected)
+		}
+	}
+}
+
+func TestFitWithin(t *testing.T) {
+	cases := []struct {
+		width, height, maxSize int
+		expectedW, expectedH   int
+	}{
+		{width: 300, height: 200, maxSize: 400, expectedW: 300, expectedH: 200},
+		{width: 4000, height: 3000, maxSize: 400, expectedW: 400, expectedH: 300},
+		{width: 3000, height: 4000, maxSize: 400, expectedW: 300, expectedH: 400},
+		{width: 10000, height: 1, maxSize: 400, expectedW: 400, expectedH: 1},
+	}
+
+	for _, c := range cases {
+		w, h := fitWithin(c.width, c.height, c.maxSize)
+		if w != c.expectedW || h != c.expectedH {
+			t.Errorf("fitWithin(%d, %d, %d) == %d×%d, expected %d×%d",
+				c.width, c.height, c.maxSize, w, h, c.expectedW, c.expectedH)
+		}
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// a 2×1 image with a red left pixel and a blue right pixel
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, red)
+	img.SetRGBA(1, 0, blue)
+
+	cases := []struct {
+		orientation int
+		expected    [][]color.RGBA
+	}{
+		{orientation: 1, expected: [][]color.RGBA{{red, blue}}},
+		{orientation: 2, expected: [][]color.RGBA{{blue, red}}},
+		{orientation: 3, expected: [][]color.RGBA{{blue, red}}},
+		{orientation: 6, expected: [][]color.RGBA{{red}, {blue}}},
+		{orientation: 8, expected: [][]color.RGBA{{blue}, {red}}},
+	}
+
+	for _, c := range cases {
+		out := orientImage(img, c.orientation)
+		if out.Bounds().Dy() != len(c.expected) || out.Bounds().Dx() != len(c.expected[0]) {
+			t.Errorf("orientImage(%d) is %v, expected %d×%d", c.orientation, out.Bounds().Size(), len(c.expected[0]), len(c.expected))
+			continue
+		}
+		for y, row := range c.expected {
+			for x, expected := range row {
+				if actual := out.RGBAAt(x, y); actual != expected {
+					t.Errorf("orientImage(%d) at %d,%d == %v, expected %v", c.orientation, x, y, actual, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	transparent := image.NewNRGBA(image.Rect(0, 0, 800, 600))
+	pngData := &bytes.Buffer{}
+	if err := png.Encode(pngData, transparent); err != nil {
+		t.Fatalf("unable to encode png: %s", err)
+	}
+
+	cases := []struct {
+		name        string
+		data        []byte
+		contentType string
+		thumb       image.Point
+		display     image.Point
+	}{
+		{
+			name:        "rotated jpeg",
+			data:        exifJPEG(t, 2000, 1000, 6),
+			contentType: "image/jpeg",
+			thumb:       image.Pt(200, 400),
+			display:     image.Pt(800, 1600),
+		},
+		{
+			name:        "transparent png",
+			data:        pngData.Bytes(),
+			contentType: "image/png",
+			thumb:       image.Pt(400, 300),
+			display:     image.Pt(800, 600),
+		},
+	}
+
+	for _, c := range cases {
+		variants, err := processImage(c.data)
+		if err != nil {
+			t.Errorf("%s: processImage() error == %v", c.name, err)
+			continue
+		}
+		for name, expected := range map[string]image.Point{"thumb": c.thumb, "display": c.display} {
+			variant := variants[name]
+			if actual := image.Pt(variant.Width, variant.Height); actual != expected {
+				t.Errorf("%s: %s is %v, expected %v", c.name, name, actual, expected)
+			}
+			if variant.ContentType != c.contentType {
+				t.Errorf("%s: %s content type == %s, expected %s", c.name, name, variant.ContentType, c.contentType)
+			}
+			if bytes.Contains(variant.Data, []byte("Exif")) {
+				t.Errorf("%s: %s still carries EXIF data", c.name, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one security-relevant event, stored as a line of JSON
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	ActorId   int       `json:"actor_id"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+}
+
+// AuditFilter narrows down a query, zero values match everything
+type AuditFilter struct {
+	ActorId int
+	Action  string
+	Target  string
+	Outcome string
+	Since   time.Time
+	Until   time.Time
+}
+
+// AuditLog is an append-only JSON Lines file, entries are never rewritten
+type AuditLog struct {
+	path string
+	mux  *sync.RWMutex
+}
+
+// NewAuditLog opens the audit log and creates the file if it doesn't exist
+func NewAuditLog(path string) (*AuditLog, error) {
+	auditLog := AuditLog{
+		path: path,
+		mux:  lockForPath(path),
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return &auditLog, nil
+}
+
+// Append writes an entry to the end of the log
+func (a *AuditLog) Append(entry AuditEntry) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	dat, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(dat, '\n'))
+	return err
+}
+
+// Query returns the entries matching filter, oldest first
+func (a *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []AuditEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry := AuditEntry{}
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, err
+		}
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	if f.ActorId != 0 && entry.ActorId != f.ActorId {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if f.Target != "" && entry.Target != f.Target {
+		return false
+	}
+	if f.Outcome != "" && entry.Outcome != f.Outcome {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditLogQuery(t *testing.T) {
+	auditLog, err := NewAuditLog(t.TempDir() + "/audit.log")
+	if err != nil {
+		t.Fatalf("unable to create audit log: %s", err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []AuditEntry{
+		{Time: start, ActorId: 1, Action: "auth.login", Target: "user:1", Outcome: "success"},
+		{Time: start.Add(time.Hour), ActorId: 0, Action: "auth.login", Target: "user:1", Outcome: "failure"},
+		{Time: start.Add(2 * time.Hour), ActorId: 1, Action: "user.delete", Target: "user:1", Outcome: "success"},
+	}
+	for _, entry := range entries {
+		err := auditLog.Append(entry)
+		if err != nil {
+			t.Fatalf("unable to append entry: %v", err)
+		}
+	}
+
+	cases := []struct {
+		filter   AuditFilter
+		expected int
+	}{
+		{filter: AuditFilter{}, expected: 3},
+		{filter: AuditFilter{Action: "auth.login"}, expected: 2},
+		{filter: AuditFilter{ActorId: 1}, expected: 2},
+		{filter: AuditFilter{Outcome: "failure"}, expected: 1},
+		{filter: AuditFilter{Since: start.Add(30 * time.Minute)}, expected: 2},
+		{filter: AuditFilter{Until: start.Add(30 * time.Minute)}, expected: 1},
+	}
+
+	for _, c := range cases {
+		actual, err := auditLog.Query(c.filter)
+		if err != nil {
+			t.Errorf("unable to query audit log: %v", err)
+			continue
+		}
+		if len(actual) != c.expected {
+			t.Errorf("Query(%+v) returned %v entries, expected %v", c.filter, len(actual), c.expected)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrBlocked = errors.New("one of the users has blocked the other")
+
+// Restriction is a block or a mute that UserId placed on TargetId
+type Restriction struct {
+	UserId    int       `json:"user_id"`
+	TargetId  int       `json:"target_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BlockUser blocks a user, which also ends any follow between the two
+func (db *DB) BlockUser(usrId int, targetId int) error {
+	return db.transact(func(structure *DBStructure) error {
+		err := addRestriction(structure, &structure.Blocks, usrId, targetId)
+		if err != nil {
+			return err
+		}
+		structure.Follows = filterFollows(structure.Follows, func(follow Follow) bool {
+			return !(follow.FollowerId == usrId && follow.FolloweeId == targetId) &&
+				!(follow.FollowerId == targetId && follow.FolloweeId == usrId)
+		})
+		rebuildTimeline(structure, usrId)
+		rebuildTimeline(structure, targetId)
+		return nil
+	})
+}
+
+func (db *DB) UnblockUser(usrId int, targetId int) error {
+	return db.transact(func(structure *DBStructure) error {
+		return removeRestriction(structure, &structure.Blocks, usrId, targetId)
+	})
+}
+
+// MuteUser hides a user's chirps from the muting user's timelines only
+func (db *DB) MuteUser(usrId int, targetId int) error {
+	return db.transact(func(structure *DBStructure) error {
+		return addRestriction(structure, &structure.Mutes, usrId, targetId)
+	})
+}
+
+func (db *DB) UnmuteUser(usrId int, targetId int) error {
+	return db.transact(func(structure *DBStructure) error {
+		return removeRestriction(structure, &structure.Mutes, usrId, targetId)
+	})
+}
+
+// GetBlocks returns every block in the database
+func (db *DB) GetBlocks() ([]Restriction, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return append([]Restriction{}, dbStruct.Blocks...), nil
+}
+
+// GetMutes returns every mute in the database
+func (db *DB) GetMutes() ([]Restriction, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return append([]Restriction{}, dbStruct.Mutes...), nil
+}
+
+// IsBlocked reports whether either user has blocked the other
+func (db *DB) IsBlocked(usrId int, otherId int) (bool, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+	return isBlocked(&dbStruct, usrId, otherId), nil
+}
+
+func isBlocked(structure *DBStructure, usrId int, otherId int) bool {
+	for _, block := range structure.Blocks {
+		if (block.UserId == usrId && block.TargetId == otherId) ||
+			(block.UserId == otherId && block.TargetId == usrId) {
+			return true
+		}
+	}
+	return false
+}
+
+// addRestriction appends a restriction unless it already exists
+func addRestriction(structure *DBStructure, restrictions *[]Restriction, usrId int, targetId int) error {
+	if usrId == targetId {
+		return fmt.Errorf("users can't restrict themselves")
+	}
+	if usr, ok := structure.Users[targetId]; !ok || usr.Deleted {
+		return fmt.Errorf("user %d not found", targetId)
+	}
+	for _, restriction := range *restrictions {
+		if restriction.UserId == usrId && restriction.TargetId == targetId {
+			return nil
+		}
+	}
+	*restrictions = append(*restrictions, Restriction{UserId: usrId, TargetId: targetId, CreatedAt: time.Now().UTC()})
+	return nil
+}
+
+func removeRestriction(structure *DBStructure, restrictions *[]Restriction, usrId int, targetId int) error {
+	if usr, ok := structure.Users[targetId]; !ok || usr.Deleted {
+		return fmt.Errorf("user %d not found", targetId)
+	}
+	*restrictions = filterRestrictions(*restrictions, func(restriction Restriction) bool {
+		return !(restriction.UserId == usrId && restriction.TargetId == targetId)
+	})
+	return nil
+}
+
+func filterRestrictions(restrictions []Restriction, keep func(restriction Restriction) bool) []Restriction {
+	kept := []Restriction{}
+	for _, restriction := range restrictions {
+		if keep(restriction) {
+			kept = append(kept, restriction)
+		}
+	}
+	return kept
+}
@@ -4,40 +4,147 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
+var ErrHandleTaken = errors.New("handle already taken")
+var ErrEmailTaken = errors.New("email already in use")
+var ErrUserNotFound = errors.New("user not found")
+var ErrAlreadyRechirped = errors.New("chirp already rechirped")
+var ErrPasswordChanged = errors.New("password changed since it was verified")
+
 type DB struct {
 	path string
 	mux  *sync.RWMutex
 }
 
 type Chirp struct {
-	Id     int    `json:"id"`
-	Body   string `json:"body"`
-	Author int    `json:"author_id"`
+	Id         int         `json:"id"`
+	Body       string      `json:"body"`
+	Author     int         `json:"author_id"`
+	Moderation *Moderation `json:"moderation,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Edited     bool        `json:"edited"`
+	EditedAt   *time.Time  `json:"edited_at,omitempty"`
+	// ReplyTo is the id of the chirp this one answers, it is kept
+	// even after the parent is deleted
+	ReplyTo int `json:"reply_to_id,omitempty"`
+	// RechirpOf is set on plain reposts, which have no body of their
+	// own and are removed together with the original
+	RechirpOf int `json:"rechirp_of_id,omitempty"`
+	// QuoteOf is set on reposts with commentary, a quote outlives
+	// the chirp it quotes
+	QuoteOf int `json:"quote_of_id,omitempty"`
+	// Tags are the normalized hashtags in the body
+	Tags []string `json:"tags,omitempty"`
+	// Mentions are the @handles in the body that resolved to a user
+	Mentions []Mention `json:"mentions,omitempty"`
+	MediaIds []string  `json:"media_ids,omitempty"`
+}
+
+// Moderation records what the content filters did to a chirp when it was posted
+type Moderation struct {
+	Flagged bool          `json:"flagged"`
+	Matches []FilterMatch `json:"matches"`
+}
+
+type FilterMatch struct {
+	Filter string `json:"filter"`
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
 }
 
 type User struct {
-	Id        int    `json:"id"`
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	RedStatus bool   `json:"is_chirpy_red"`
+	Id          int    `json:"id"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	RedStatus   bool   `json:"is_chirpy_red"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	Deleted     bool   `json:"deleted"`
+	Role        string `json:"role"`
 }
 
+// DeletionPolicy controls what happens to a user's chirps when they delete their account
+type DeletionPolicy int
+
+const (
+	// DeleteChirps removes the user and every chirp they wrote
+	DeleteChirps DeletionPolicy = iota
+	// AnonymizeChirps keeps the chirps but strips the user down to an anonymous tombstone
+	AnonymizeChirps
+)
+
 type RefreshToken struct {
 	Token      string    `json:"token"`
 	Expiration time.Time `json:"expiration"`
 	Id         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	// ClientId and Scopes are only set on tokens issued to OAuth2 clients
+	ClientId string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+type SubscriptionEvent struct {
+	UserId    int       `json:"user_id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type DBStructure struct {
-	Chirps        map[int]Chirp           `json:"chirps"`
-	Users         map[int]User            `json:"users"`
-	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	Chirps             map[int]Chirp                `json:"chirps"`
+	Users              map[int]User                 `json:"users"`
+	RefreshTokens      map[string]RefreshToken      `json:"refresh_tokens"`
+	SubscriptionEvents []SubscriptionEvent          `json:"subscription_events"`
+	RoleChanges        []RoleChange                 `json:"role_changes"`
+	AccessTokens       map[string]AccessToken       `json:"access_tokens"`
+	OAuthClients       map[string]OAuthClient       `json:"oauth_clients"`
+	AuthorizationCodes map[string]AuthorizationCode `json:"authorization_codes"`
+	ChirpRevisions     map[int][]ChirpRevision      `json:"chirp_revisions"`
+	Likes              []Like                       `json:"likes"`
+	Follows            []Follow                     `json:"follows"`
+	Blocks             []Restriction                `json:"blocks"`
+	Mutes              []Restriction                `json:"mutes"`
+	// Timelines holds the chirp ids on each user's home timeline, newest first
+	Timelines map[int][]int `json:"timelines"`
+	// TagCounts is the number of chirps carrying each hashtag
+	TagCounts     map[string]int `json:"tag_counts"`
+	Notifications []Notification `json:"notifications"`
+	// SearchIndex is derived from Chirps and only kept in memory
+	SearchIndex SearchIndex      `json:"-"`
+	Media       map[string]Media `json:"media"`
+	// OrphanedBlobs are blob keys of deleted media waiting to be removed
+	OrphanedBlobs []string `json:"orphaned_blobs"`
+	// ScheduledChirps are kept apart from Chirps until they are published
+	ScheduledChirps map[int]ScheduledChirp `json:"scheduled_chirps"`
+	// the last ids handed out, so ids of deleted rows are never reused
+	LastUserId         int `json:"last_user_id"`
+	LastChirpId        int `json:"last_chirp_id"`
+	LastNotificationId int `json:"last_notification_id"`
+	LastScheduledId    int `json:"last_scheduled_id"`
+}
+
+// every handle to the same file shares one lock so that
+// read-modify-write cycles from concurrent requests don't interleave
+var (
+	pathLocksMux = &sync.Mutex{}
+	pathLocks    = map[string]*sync.RWMutex{}
+)
+
+func lockForPath(path string) *sync.RWMutex {
+	pathLocksMux.Lock()
+	defer pathLocksMux.Unlock()
+	if _, ok := pathLocks[path]; !ok {
+		pathLocks[path] = &sync.RWMutex{}
+	}
+	return pathLocks[path]
 }
 
 // NewDB creates a new database connection
@@ -45,7 +152,7 @@ type DBStructure struct {
 func NewDB(path string) (*DB, error) {
 	newDb := DB{
 		path: path,
-		mux:  &sync.RWMutex{},
+		mux:  lockForPath(path),
 	}
 
 	err := newDb.ensureDB()
@@ -58,37 +165,60 @@ func NewDB(path string) (*DB, error) {
 
 // Create a new user and add to the db
 func (db *DB) CreateUser(email string, password string) (User, error) {
-	users, err := db.GetUsers()
+	return db.InsertUser(User{Email: email, Password: password})
+}
+
+// InsertUser assigns the next id to a new user with its profile fields and
+// saves it, the email and handle are checked to be free in the same write
+func (db *DB) InsertUser(usr User) (User, error) {
+	newUser := User{}
+	err := db.transact(func(structure *DBStructure) error {
+		if emailTaken(structure, 0, usr.Email) {
+			return ErrEmailTaken
+		}
+		if handleTaken(structure, 0, usr.Handle) {
+			return ErrHandleTaken
+		}
+		newUser = User{
+			Id:          nextUserId(structure),
+			Email:       usr.Email,
+			Password:    usr.Password,
+			RedStatus:   false,
+			Role:        RoleUser,
+			Handle:      usr.Handle,
+			DisplayName: usr.DisplayName,
+		}
+		structure.Users[newUser.Id] = newUser
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
-	newUser := User{
-		Id:        len(users) + 1,
-		Email:     email,
-		Password:  password,
-		RedStatus: false,
-	}
-
-	users = append(users, newUser)
-	userMap := make(map[int]User)
+	return newUser, nil
+}
 
-	for i, innerUser := range users {
-		if _, ok := userMap[i+1]; ok {
-			return User{}, fmt.Errorf("user %s already exists", email)
+// emailTaken reports whether a user other than usrId signs in with the
+// email, emails are compared case-insensitively
+func emailTaken(structure *DBStructure, usrId int, email string) bool {
+	for _, other := range structure.Users {
+		if other.Id != usrId && !other.Deleted && strings.EqualFold(other.Email, email) {
+			return true
 		}
-		userMap[i+1] = innerUser
-	}
-	structure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
 	}
-	structure.Users = userMap
+	return false
+}
 
-	errWrite := db.writeDB(structure)
-	if errWrite != nil {
-		return User{}, errWrite
+// handleTaken reports whether a user other than usrId has the handle
+func handleTaken(structure *DBStructure, usrId int, handle string) bool {
+	if handle == "" {
+		return false
 	}
-	return newUser, nil
+	for _, other := range structure.Users {
+		if other.Id != usrId && strings.EqualFold(other.Handle, handle) {
+			return true
+		}
+	}
+	return false
 }
 
 func (db *DB) CreateRefreshToken(expiration time.Time, usrId int) (RefreshToken, error) {
@@ -101,19 +231,40 @@ func (db *DB) CreateRefreshToken(expiration time.Time, usrId int) (RefreshToken,
 		Token:      refreshToken,
 		Expiration: expiration,
 		Id:         usrId,
+		CreatedAt:  time.Now().UTC(),
 	}
 
-	dbStruct, err := db.loadDB()
+	err := db.transact(func(structure *DBStructure) error {
+		structure.RefreshTokens[tokenStruct.Token] = tokenStruct
+		return nil
+	})
 	if err != nil {
 		return RefreshToken{}, err
 	}
+	return tokenStruct, nil
+}
 
-	if dbStruct.RefreshTokens == nil {
-		dbStruct.RefreshTokens = make(map[string]RefreshToken)
+// CreateClientRefreshToken issues a refresh token to an OAuth2 client acting for a user
+func (db *DB) CreateClientRefreshToken(expiration time.Time, usrId int, clientId string, scopes []string) (RefreshToken, error) {
+	randomData := make([]byte, 32)
+	_, err := rand.Read(randomData)
+	if err != nil {
+		return RefreshToken{}, err
 	}
 
-	dbStruct.RefreshTokens[tokenStruct.Token] = tokenStruct
-	err = db.writeDB(dbStruct)
+	tokenStruct := RefreshToken{
+		Token:      hex.EncodeToString(randomData),
+		Expiration: expiration,
+		Id:         usrId,
+		CreatedAt:  time.Now().UTC(),
+		ClientId:   clientId,
+		Scopes:     scopes,
+	}
+
+	err = db.transact(func(structure *DBStructure) error {
+		structure.RefreshTokens[tokenStruct.Token] = tokenStruct
+		return nil
+	})
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -137,81 +288,266 @@ func (db *DB) CheckRefreshToken(token string) (RefreshToken, error) {
 	return dbStruct.RefreshTokens[token], nil
 }
 
-func (db *DB) DeleteToken(token string) error {
+// GetRefreshTokens returns every refresh token issued to a user
+func (db *DB) GetRefreshTokens(usrId int) ([]RefreshToken, error) {
 	dbStruct, err := db.loadDB()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	if _, ok := dbStruct.RefreshTokens[token]; !ok {
-		return fmt.Errorf("refresh token not found")
+	tokens := []RefreshToken{}
+	for _, token := range dbStruct.RefreshTokens {
+		if token.Id == usrId {
+			tokens = append(tokens, token)
+		}
 	}
+	return tokens, nil
+}
+
+func (db *DB) DeleteToken(token string) error {
+	return db.transact(func(structure *DBStructure) error {
+		if _, ok := structure.RefreshTokens[token]; !ok {
+			return fmt.Errorf("refresh token not found")
+		}
+		delete(structure.RefreshTokens, token)
+		return nil
+	})
+}
 
-	delete(dbStruct.RefreshTokens, token)
+// RecordSubscriptionEvent appends a billing event for a user
+func (db *DB) RecordSubscriptionEvent(usrId int, event string) error {
+	return db.transact(func(structure *DBStructure) error {
+		structure.SubscriptionEvents = append(structure.SubscriptionEvents, SubscriptionEvent{
+			UserId:    usrId,
+			Event:     event,
+			CreatedAt: time.Now().UTC(),
+		})
+		return nil
+	})
+}
 
-	err = db.writeDB(dbStruct)
+// GetSubscriptionEvents returns the billing events recorded for a user
+func (db *DB) GetSubscriptionEvents(usrId int) ([]SubscriptionEvent, error) {
+	dbStruct, err := db.loadDB()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	events := []SubscriptionEvent{}
+	for _, event := range dbStruct.SubscriptionEvents {
+		if event.UserId == usrId {
+			events = append(events, event)
+		}
+	}
+	return events, nil
 }
 
 // CreateChirp creates a new chirp and saves it to disk
 func (db *DB) CreateChirp(body string, author int) (Chirp, error) {
-	chirps, err := db.GetChirps()
+	return db.InsertChirp(Chirp{Body: body, Author: author})
+}
+
+// InsertChirp assigns the next id to a fully populated chirp and saves it to disk
+func (db *DB) InsertChirp(chirp Chirp) (Chirp, error) {
+	if chirp.CreatedAt.IsZero() {
+		chirp.CreatedAt = time.Now().UTC()
+	}
+	err := db.transact(func(structure *DBStructure) error {
+		var err error
+		chirp, err = insertChirp(structure, chirp)
+		return err
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
-	newChirp := Chirp{
-		Id:     len(chirps) + 1,
-		Body:   body,
-		Author: author,
-	}
-
-	chirps = append(chirps, newChirp)
-	chirpMap := make(map[int]Chirp)
+	return chirp, nil
+}
 
-	for i, innerChirp := range chirps {
-		chirpMap[i+1] = innerChirp
+// insertChirp adds a chirp and everything that follows from publishing it:
+// tag counts, the search index, timelines and notifications
+func insertChirp(structure *DBStructure, chirp Chirp) (Chirp, error) {
+	if chirp.RechirpOf != 0 {
+		original, ok := structure.Chirps[chirp.RechirpOf]
+		if !ok || original.RechirpOf != 0 {
+			return Chirp{}, fmt.Errorf("chirp not found")
+		}
+		for _, other := range structure.Chirps {
+			if other.RechirpOf == chirp.RechirpOf && other.Author == chirp.Author {
+				return Chirp{}, ErrAlreadyRechirped
+			}
+		}
 	}
-	structure, err := db.loadDB()
-	if err != nil {
+	if _, ok := structure.Chirps[chirp.QuoteOf]; chirp.QuoteOf != 0 && !ok {
+		return Chirp{}, fmt.Errorf("chirp not found")
+	}
+	chirp.Id = nextChirpId(structure)
+	if err := attachMedia(structure, chirp); err != nil {
 		return Chirp{}, err
 	}
-	structure.Chirps = chirpMap
+	structure.Chirps[chirp.Id] = chirp
+	countTags(structure, chirp.Tags, 1)
+	indexChirp(structure, chirp)
+	fanOut(structure, chirp)
+	notifyReply(structure, chirp)
+	notifyMentions(structure, chirp, nil)
+	return chirp, nil
+}
+
+// UpdateUser replaces the stored fields of an existing user
+func (db *DB) UpdateUser(usrId int, update User) (User, error) {
+	updatedUser := User{}
+	err := db.transact(func(structure *DBStructure) error {
+		usr, ok := structure.Users[usrId]
+		if !ok || usr.Deleted {
+			return fmt.Errorf("user %d not found", usrId)
+		}
+		if !strings.EqualFold(usr.Email, update.Email) && emailTaken(structure, usrId, update.Email) {
+			return ErrEmailTaken
+		}
+		if handleTaken(structure, usrId, update.Handle) {
+			return ErrHandleTaken
+		}
 
-	errWrite := db.writeDB(structure)
-	if errWrite != nil {
-		return Chirp{}, errWrite
+		updatedUser = usr
+		updatedUser.Password = update.Password
+		updatedUser.Email = update.Email
+		updatedUser.RedStatus = update.RedStatus
+		updatedUser.Handle = update.Handle
+		updatedUser.DisplayName = update.DisplayName
+		updatedUser.Bio = update.Bio
+		updatedUser.AvatarURL = update.AvatarURL
+		structure.Users[usrId] = updatedUser
+		return nil
+	})
+	if err != nil {
+		return User{}, err
 	}
-	return newChirp, nil
+	return updatedUser, nil
 }
 
-// Update Pwd returns all chirps in the database
-func (db *DB) UpdateUser(usrId int, update User) (User, error) {
+// UpgradePasswordHash replaces only the password hash of a user, and only if
+// the stored hash is still the one the password was verified against
+func (db *DB) UpgradePasswordHash(usrId int, verifiedHash string, rehashed string) error {
+	return db.transact(func(structure *DBStructure) error {
+		usr, ok := structure.Users[usrId]
+		if !ok || usr.Deleted {
+			return fmt.Errorf("user %d not found", usrId)
+		}
+		if usr.Password != verifiedHash {
+			return ErrPasswordChanged
+		}
+		usr.Password = rehashed
+		structure.Users[usrId] = usr
+		return nil
+	})
+}
 
-	userMap, err := db.loadDB()
+// GetUser returns a single user by id
+func (db *DB) GetUser(usrId int) (User, error) {
+	dbStruct, err := db.loadDB()
 	if err != nil {
 		return User{}, err
 	}
-	if _, ok := userMap.Users[usrId]; !ok {
+	usr, ok := dbStruct.Users[usrId]
+	if !ok || usr.Deleted {
 		return User{}, fmt.Errorf("user %d not found", usrId)
 	}
+	return usr, nil
+}
 
-	updatedUser := userMap.Users[usrId]
-	updatedUser.Password = update.Password
-	updatedUser.Email = update.Email
-	updatedUser.RedStatus = update.RedStatus
-	userMap.Users[usrId] = updatedUser
-	err = db.writeDB(userMap)
+// DeleteUser removes a user, revokes all of their refresh and access tokens and
+// deletes or keeps their chirps depending on policy in a single write
+func (db *DB) DeleteUser(usrId int, policy DeletionPolicy) error {
+	return db.transact(func(structure *DBStructure) error {
+		usr, ok := structure.Users[usrId]
+		if !ok || usr.Deleted {
+			return fmt.Errorf("user %d not found", usrId)
+		}
+
+		for token, refreshToken := range structure.RefreshTokens {
+			if refreshToken.Id == usrId {
+				delete(structure.RefreshTokens, token)
+			}
+		}
+		for id, accessToken := range structure.AccessTokens {
+			if accessToken.UserId == usrId {
+				delete(structure.AccessTokens, id)
+			}
+		}
+		for hash, code := range structure.AuthorizationCodes {
+			if code.UserId == usrId {
+				delete(structure.AuthorizationCodes, hash)
+			}
+		}
+		structure.Likes = filterLikes(structure.Likes, func(like Like) bool {
+			return like.UserId != usrId
+		})
+		followers := []int{}
+		for _, follow := range structure.Follows {
+			if follow.FolloweeId == usrId {
+				followers = append(followers, follow.FollowerId)
+			}
+		}
+		structure.Follows = filterFollows(structure.Follows, func(follow Follow) bool {
+			return follow.FollowerId != usrId && follow.FolloweeId != usrId
+		})
+		notInvolvingUser := func(restriction Restriction) bool {
+			return restriction.UserId != usrId && restriction.TargetId != usrId
+		}
+		structure.Blocks = filterRestrictions(structure.Blocks, notInvolvingUser)
+		structure.Mutes = filterRestrictions(structure.Mutes, notInvolvingUser)
+		delete(structure.Timelines, usrId)
+		for id, scheduled := range structure.ScheduledChirps {
+			if scheduled.Chirp.Author == usrId {
+				delete(structure.ScheduledChirps, id)
+			}
+		}
+		deleteMedia(structure, func(media Media) bool {
+			return media.OwnerId == usrId && media.ChirpId == 0
+		})
+		structure.Notifications = filterNotifications(structure.Notifications, func(notification Notification) bool {
+			return notification.UserId != usrId && notification.ActorId != usrId
+		})
+
+		switch policy {
+		case AnonymizeChirps:
+			structure.Users[usrId] = User{Id: usrId, Deleted: true}
+		default:
+			for id, chirp := range structure.Chirps {
+				if chirp.Author == usrId {
+					deleteChirp(structure, id)
+				}
+			}
+			delete(structure.Users, usrId)
+			events := []SubscriptionEvent{}
+			for _, event := range structure.SubscriptionEvents {
+				if event.UserId != usrId {
+					events = append(events, event)
+				}
+			}
+			structure.SubscriptionEvents = events
+		}
+		// the deleted user's chirps no longer belong on their followers' timelines
+		for _, followerId := range followers {
+			rebuildTimeline(structure, followerId)
+		}
+		return nil
+	})
+}
+
+// GetUserByHandle looks up a user by their case-insensitive handle
+func (db *DB) GetUserByHandle(handle string) (User, error) {
+	dbStruct, err := db.loadDB()
 	if err != nil {
 		return User{}, err
 	}
-
-	return updatedUser, nil
+	for _, usr := range dbStruct.Users {
+		if !usr.Deleted && usr.Handle != "" && strings.EqualFold(usr.Handle, handle) {
+			return usr, nil
+		}
+	}
+	return User{}, fmt.Errorf("user @%s not found", handle)
 }
 
-// GetUsers returns all chirps in the database
+// GetUsers returns all users in the database
 func (db *DB) GetUsers() ([]User, error) {
 	user := []User{}
 
@@ -221,23 +557,59 @@ func (db *DB) GetUsers() ([]User, error) {
 	}
 	//fmt.Printf("Chirp map len: %v\n", len(chirpMap.Chirps))
 	for _, usr := range userMap.Users {
+		if usr.Deleted {
+			continue
+		}
 		user = append(user, usr)
 	}
 	return user, nil
 }
 
-// GetChirps returns all chirps in the database
+// DeleteChirp removes a chirp together with its revision history
 func (db *DB) DeleteChirp(id int) error {
-	chirpMap, err := db.loadDB()
+	return db.transact(func(structure *DBStructure) error {
+		if _, ok := structure.Chirps[id]; !ok {
+			return fmt.Errorf("chirp not found")
+		}
+		deleteChirp(structure, id)
+		return nil
+	})
+}
+
+// deleteChirp removes a chirp along with its revisions, likes and rechirps
+func deleteChirp(structure *DBStructure, id int) {
+	countTags(structure, structure.Chirps[id].Tags, -1)
+	unindexChirp(structure, structure.Chirps[id])
+	delete(structure.Chirps, id)
+	delete(structure.ChirpRevisions, id)
+	structure.Likes = filterLikes(structure.Likes, func(like Like) bool {
+		return like.ChirpId != id
+	})
+	removeFromTimelines(structure, id)
+	deleteMedia(structure, func(media Media) bool {
+		return media.ChirpId == id
+	})
+	structure.Notifications = filterNotifications(structure.Notifications, func(notification Notification) bool {
+		return notification.ChirpId != id
+	})
+	for rechirpId, chirp := range structure.Chirps {
+		if chirp.RechirpOf == id {
+			deleteChirp(structure, rechirpId)
+		}
+	}
+}
+
+// GetChirp returns a single chirp by id
+func (db *DB) GetChirp(id int) (Chirp, error) {
+	dbStruct, err := db.loadDB()
 	if err != nil {
-		return err
+		return Chirp{}, err
 	}
-	if _, ok := chirpMap.Chirps[id]; ok {
-		delete(chirpMap.Chirps, id)
-		err = db.writeDB(chirpMap)
-		return err
+	chirp, ok := dbStruct.Chirps[id]
+	if !ok {
+		return Chirp{}, fmt.Errorf("chirp not found")
 	}
-	return fmt.Errorf("chirp not found")
+	return chirp, nil
 }
 
 // GetChirps returns all chirps in the database
@@ -272,39 +644,127 @@ func (db *DB) ensureDB() error {
 func (db *DB) loadDB() (DBStructure, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
+	return db.readStructure()
+}
+
+// transact loads the database, applies fn and writes the result back
+// while holding the lock, so the whole change lands or none of it does
+func (db *DB) transact(fn func(structure *DBStructure) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	structure, err := db.readStructure()
+	if err != nil {
+		return err
+	}
+	// the cached index is shared with readers, fn changes a copy of it
+	structure.SearchIndex = structure.SearchIndex.clone()
+	err = fn(&structure)
+	if err != nil {
+		return err
+	}
+	return db.writeStructure(structure)
+}
+
+// readStructure reads the database file, the caller must hold the lock
+func (db *DB) readStructure() (DBStructure, error) {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return DBStructure{}, err
+	}
 	f, err := os.ReadFile(db.path)
 	if err != nil {
 		return DBStructure{}, err
 	}
-	structure := DBStructure{
-		Chirps: make(map[int]Chirp),
+	structure := DBStructure{}
+
+	if len(f) > 0 {
+		decodeErr := json.Unmarshal(f, &structure)
+		if decodeErr != nil {
+			return DBStructure{}, decodeErr
+		}
 	}
 
-	//fmt.Printf("Read: %s\n", f)
-	decodeErr := json.Unmarshal(f, &structure)
-	if decodeErr != nil {
-		return DBStructure{}, err
+	if structure.Chirps == nil {
+		structure.Chirps = make(map[int]Chirp)
+	}
+	if structure.Users == nil {
+		structure.Users = make(map[int]User)
+	}
+	if structure.RefreshTokens == nil {
+		structure.RefreshTokens = make(map[string]RefreshToken)
+	}
+	if structure.AccessTokens == nil {
+		structure.AccessTokens = make(map[string]AccessToken)
+	}
+	if structure.OAuthClients == nil {
+		structure.OAuthClients = make(map[string]OAuthClient)
+	}
+	if structure.AuthorizationCodes == nil {
+		structure.AuthorizationCodes = make(map[string]AuthorizationCode)
+	}
+	if structure.ChirpRevisions == nil {
+		structure.ChirpRevisions = make(map[int][]ChirpRevision)
+	}
+	if structure.Timelines == nil {
+		structure.Timelines = make(map[int][]int)
+	}
+	if structure.TagCounts == nil {
+		structure.TagCounts = make(map[string]int)
+	}
+	if structure.Media == nil {
+		structure.Media = make(map[string]Media)
+	}
+	if structure.ScheduledChirps == nil {
+		structure.ScheduledChirps = make(map[int]ScheduledChirp)
+	}
+	if index, ok := cachedSearchIndex(db.path, info); ok {
+		structure.SearchIndex = index
+	} else {
+		buildSearchIndex(&structure)
+		cacheSearchIndex(db.path, info, structure.SearchIndex)
 	}
-	//fmt.Printf("Read chirp: %s\n", structure.Chirps[1].Body)
 
 	return structure, nil
 }
 
-// writeDB writes the database file to disk
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
-
+// writeStructure writes the database file and caches the search index that
+// goes with it, the caller must hold the lock
+func (db *DB) writeStructure(dbStructure DBStructure) error {
 	dat, err := json.Marshal(dbStructure)
 	if err != nil {
 		return err
 	}
-	//	fmt.Printf("Writing: %s\n", dat)
 
 	writeErr := os.WriteFile(db.path, dat, 0644)
 	if writeErr != nil {
 		return writeErr
 	}
 
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	cacheSearchIndex(db.path, info, dbStructure.SearchIndex)
 	return nil
 }
+
+func nextUserId(structure *DBStructure) int {
+	for id := range structure.Users {
+		if id > structure.LastUserId {
+			structure.LastUserId = id
+		}
+	}
+	structure.LastUserId++
+	return structure.LastUserId
+}
+
+func nextChirpId(structure *DBStructure) int {
+	for id := range structure.Chirps {
+		if id > structure.LastChirpId {
+			structure.LastChirpId = id
+		}
+	}
+	structure.LastChirpId++
+	return structure.LastChirpId
+}
@@ -1,8 +1,13 @@
 package database
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreateUser(t *testing.T) {
@@ -166,3 +171,793 @@ func TestCreateTweet(t *testing.T) {
 	}
 
 }
+
+func TestDeleteUser(t *testing.T) {
+	cases := []struct {
+		policy         DeletionPolicy
+		expectedChirps int
+	}{
+		{policy: DeleteChirps, expectedChirps: 1},
+		{policy: AnonymizeChirps, expectedChirps: 3},
+	}
+
+	for _, c := range cases {
+		db, err := NewDB(t.TempDir() + "/db.json")
+		if err != nil {
+			t.Fatalf("unable to create db: %s", err)
+		}
+		usr, _ := db.CreateUser("delete@boot.dev", "pwd")
+		other, _ := db.CreateUser("keep@boot.dev", "pwd")
+		db.CreateChirp("chirp 1", usr.Id)
+		db.CreateChirp("chirp 2", usr.Id)
+		db.CreateChirp("chirp 3", other.Id)
+		token, _ := db.CreateRefreshToken(time.Now().Add(time.Hour), usr.Id)
+
+		err = db.DeleteUser(usr.Id, c.policy)
+		if err != nil {
+			t.Errorf("unable to delete user: %v", err)
+			continue
+		}
+
+		chirps, _ := db.GetChirps()
+		if len(chirps) != c.expectedChirps {
+			t.Errorf("chirp count == %v, expected %v", len(chirps), c.expectedChirps)
+		}
+		if _, err := db.GetUser(usr.Id); err == nil {
+			t.Errorf("user %d still exists", usr.Id)
+		}
+		if _, err := db.CheckRefreshToken(token.Token); err == nil {
+			t.Errorf("refresh token for user %d was not revoked", usr.Id)
+		}
+		newUser, _ := db.CreateUser("new@boot.dev", "pwd")
+		if newUser.Id == usr.Id || newUser.Id == other.Id {
+			t.Errorf("user id %d was reused", newUser.Id)
+		}
+	}
+}
+
+func TestLikeChirp(t *testing.T) {
+	cases := []struct {
+		likes         int
+		unlike        bool
+		expectedLikes int
+	}{
+		{likes: 1, expectedLikes: 1},
+		{likes: 3, expectedLikes: 1},
+		{likes: 2, unlike: true, expectedLikes: 0},
+	}
+
+	for _, c := range cases {
+		db, err := NewDB(t.TempDir() + "/db.json")
+		if err != nil {
+			t.Fatalf("unable to create db: %s", err)
+		}
+		usr, _ := db.CreateUser("like@boot.dev", "pwd")
+		chirp, _ := db.CreateChirp("chirp", usr.Id)
+		for i := 0; i < c.likes; i++ {
+			if err := db.LikeChirp(usr.Id, chirp.Id); err != nil {
+				t.Errorf("unable to like chirp: %v", err)
+			}
+		}
+		if c.unlike {
+			db.UnlikeChirp(usr.Id, chirp.Id)
+		}
+
+		likes, _ := db.GetLikes()
+		if len(likes) != c.expectedLikes {
+			t.Errorf("like count == %v, expected %v", len(likes), c.expectedLikes)
+		}
+		if err := db.LikeChirp(usr.Id, chirp.Id+1); err == nil {
+			t.Errorf("liked a chirp that doesn't exist")
+		}
+	}
+}
+
+func TestRechirp(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := db.CreateUser("rechirp@boot.dev", "pwd")
+	original, _ := db.CreateChirp("original", usr.Id)
+
+	rechirp, err := db.InsertChirp(Chirp{Author: usr.Id, RechirpOf: original.Id})
+	if err != nil {
+		t.Fatalf("unable to rechirp: %v", err)
+	}
+	if _, err := db.InsertChirp(Chirp{Author: usr.Id, RechirpOf: original.Id}); !errors.Is(err, ErrAlreadyRechirped) {
+		t.Errorf("second rechirp error == %v, expected %v", err, ErrAlreadyRechirped)
+	}
+	quote, err := db.InsertChirp(Chirp{Body: "quote", Author: usr.Id, QuoteOf: original.Id})
+	if err != nil {
+		t.Fatalf("unable to quote: %v", err)
+	}
+
+	db.DeleteChirp(original.Id)
+	if _, err := db.GetChirp(rechirp.Id); err == nil {
+		t.Errorf("rechirp %d outlived its original", rechirp.Id)
+	}
+	if _, err := db.GetChirp(quote.Id); err != nil {
+		t.Errorf("quote %d was deleted with its original", quote.Id)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	reader, _ := db.CreateUser("reader@boot.dev", "pwd")
+	followed, _ := db.CreateUser("followed@boot.dev", "pwd")
+	stranger, _ := db.CreateUser("stranger@boot.dev", "pwd")
+	db.CreateChirp("before follow", followed.Id)
+	db.FollowUser(reader.Id, followed.Id)
+	db.CreateChirp("own", reader.Id)
+	db.CreateChirp("after follow", followed.Id)
+	db.CreateChirp("not followed", stranger.Id)
+
+	cases := []struct {
+		before       int
+		limit        int
+		expected     []string
+		expectedNext bool
+	}{
+		{limit: 10, expected: []string{"after follow", "own", "before follow"}},
+		{limit: 2, expected: []string{"after follow", "own"}, expectedNext: true},
+		{before: 2, limit: 10, expected: []string{"before follow"}},
+	}
+
+	for _, c := range cases {
+		chirps, next, err := db.GetTimeline(reader.Id, c.before, c.limit)
+		if err != nil {
+			t.Errorf("unable to get timeline: %v", err)
+			continue
+		}
+		bodies := []string{}
+		for _, chirp := range chirps {
+			bodies = append(bodies, chirp.Body)
+		}
+		if fmt.Sprint(bodies) != fmt.Sprint(c.expected) {
+			t.Errorf("timeline == %v, expected %v", bodies, c.expected)
+		}
+		if (next != 0) != c.expectedNext {
+			t.Errorf("next cursor == %v, expected a cursor: %v", next, c.expectedNext)
+		}
+	}
+
+	db.UnfollowUser(reader.Id, followed.Id)
+	chirps, _, _ := db.GetTimeline(reader.Id, 0, 10)
+	if len(chirps) != 1 {
+		t.Errorf("timeline after unfollow has %v chirps, expected 1", len(chirps))
+	}
+}
+
+func TestBlockUser(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := db.CreateUser("blocker@boot.dev", "pwd")
+	other, _ := db.CreateUser("blocked@boot.dev", "pwd")
+	db.FollowUser(usr.Id, other.Id)
+	db.FollowUser(other.Id, usr.Id)
+
+	err = db.BlockUser(usr.Id, other.Id)
+	if err != nil {
+		t.Fatalf("unable to block user: %v", err)
+	}
+	follows, _ := db.GetFollows()
+	if len(follows) != 0 {
+		t.Errorf("follow count after block == %v, expected 0", len(follows))
+	}
+	for _, pair := range [][2]int{{usr.Id, other.Id}, {other.Id, usr.Id}} {
+		if blocked, _ := db.IsBlocked(pair[0], pair[1]); !blocked {
+			t.Errorf("IsBlocked(%d, %d) == false, expected true", pair[0], pair[1])
+		}
+	}
+	if err := db.FollowUser(other.Id, usr.Id); !errors.Is(err, ErrBlocked) {
+		t.Errorf("follow after block error == %v, expected %v", err, ErrBlocked)
+	}
+
+	db.UnblockUser(usr.Id, other.Id)
+	if blocked, _ := db.IsBlocked(other.Id, usr.Id); blocked {
+		t.Errorf("users are still blocked after unblock")
+	}
+}
+
+func TestNotificationGroups(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	author, _ := db.CreateUser("author@boot.dev", "pwd")
+	chirp, _ := db.CreateChirp("chirp", author.Id)
+	fans := []User{}
+	for i := 0; i < 3; i++ {
+		fan, _ := db.CreateUser(fmt.Sprintf("fan%d@boot.dev", i), "pwd")
+		db.LikeChirp(fan.Id, chirp.Id)
+		db.FollowUser(fan.Id, author.Id)
+		fans = append(fans, fan)
+	}
+	db.LikeChirp(author.Id, chirp.Id)
+	db.InsertChirp(Chirp{Body: "reply", Author: fans[0].Id, ReplyTo: chirp.Id})
+
+	groups, _, err := db.GetNotificationGroups(author.Id, false, 0, 10)
+	if err != nil {
+		t.Fatalf("unable to get notifications: %v", err)
+	}
+	summary := []string{}
+	for _, group := range groups {
+		summary = append(summary, fmt.Sprintf("%s:%d", group.Type, group.Count))
+	}
+	if expected := "[reply:1 follow:3 like:3]"; fmt.Sprint(summary) != expected {
+		t.Errorf("notifications == %v, expected %v", summary, expected)
+	}
+
+	db.MarkNotificationRead(author.Id, groups[2].Id)
+	unread, _ := db.CountUnreadNotifications(author.Id)
+	if unread != 2 {
+		t.Errorf("unread count after marking likes read == %v, expected 2", unread)
+	}
+	db.MarkAllNotificationsRead(author.Id)
+	unread, _ = db.CountUnreadNotifications(author.Id)
+	if unread != 0 {
+		t.Errorf("unread count after marking all read == %v, expected 0", unread)
+	}
+
+	// a read like isn't retracted by an unlike, so a like, read, unlike,
+	// re-like and read leaves two rows for the same actor in one group
+	second, _ := db.CreateChirp("second", author.Id)
+	db.LikeChirp(fans[0].Id, second.Id)
+	db.MarkAllNotificationsRead(author.Id)
+	db.UnlikeChirp(fans[0].Id, second.Id)
+	db.LikeChirp(fans[0].Id, second.Id)
+	db.MarkAllNotificationsRead(author.Id)
+	groups, _, _ = db.GetNotificationGroups(author.Id, false, 0, 10)
+	for _, group := range groups {
+		if group.Type == NotificationLike && group.ChirpId == second.Id && (group.Count != 1 || len(group.ActorIds) != 1) {
+			t.Errorf("like group after a re-like has count %d and actors %v, expected one actor", group.Count, group.ActorIds)
+		}
+	}
+}
+
+func TestSearchChirps(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := db.CreateUser("search@boot.dev", "pwd")
+	other, _ := db.CreateUser("other@boot.dev", "pwd")
+	db.CreateChirp("Learning Go is fun", usr.Id)
+	db.CreateChirp("fun fact: go learning never stops #go", other.Id)
+	deleted, _ := db.CreateChirp("go go go", usr.Id)
+	db.DeleteChirp(deleted.Id)
+
+	cases := []struct {
+		query    SearchQuery
+		expected int
+	}{
+		{query: SearchQuery{Terms: QueryTerms("GO fun")}, expected: 2},
+		{query: SearchQuery{Phrases: [][]string{QueryTerms("learning go")}}, expected: 1},
+		{query: SearchQuery{Terms: QueryTerms("#go")}, expected: 1},
+		{query: SearchQuery{Terms: QueryTerms("go"), AuthorId: other.Id}, expected: 1},
+		{query: SearchQuery{Terms: QueryTerms("missing")}, expected: 0},
+		{query: SearchQuery{AuthorId: usr.Id}, expected: 1},
+	}
+
+	for _, c := range cases {
+		hits, err := db.SearchChirps(c.query)
+		if err != nil {
+			t.Errorf("unable to search: %v", err)
+			continue
+		}
+		if len(hits) != c.expected {
+			t.Errorf("search %+v found %v chirps, expected %v", c.query, len(hits), c.expected)
+		}
+	}
+}
+
+func TestPublishScheduledChirp(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	author, _ := db.CreateUser("author@boot.dev", "pwd")
+	follower, _ := db.CreateUser("follower@boot.dev", "pwd")
+	db.FollowUser(follower.Id, author.Id)
+	fan, _ := db.InsertUser(User{Email: "fan@boot.dev", Password: "pwd", Handle: "fan"})
+	parent, _ := db.CreateChirp("parent", author.Id)
+	now := time.Now().UTC()
+
+	mentionFan := []MentionCandidate{{Handle: "Fan", Start: 3, End: 7}}
+	staleMention := []Mention{{UserId: fan.Id, Start: 3, End: 7}}
+	cases := []struct {
+		name             string
+		chirp            Chirp
+		candidates       []MentionCandidate
+		checkErr         error
+		deleteParent     bool
+		block            bool
+		expectedStatus   string
+		expectedMentions int
+	}{
+		{name: "plain", chirp: Chirp{Body: "later #go", Tags: []string{"go"}}},
+		{name: "reply", chirp: Chirp{Body: "reply", ReplyTo: parent.Id}},
+		{name: "deleted parent", chirp: Chirp{Body: "orphan", ReplyTo: parent.Id}, deleteParent: true, expectedStatus: ScheduledFailed},
+		{name: "rejected by check", chirp: Chirp{Body: "rejected"}, checkErr: errors.New("rejected"), expectedStatus: ScheduledFailed},
+		{name: "mention", chirp: Chirp{Body: "hi @fan", Mentions: staleMention}, candidates: mentionFan, expectedMentions: 1},
+		{name: "mention blocked after scheduling", chirp: Chirp{Body: "hi @fan!", Mentions: staleMention}, candidates: mentionFan, block: true},
+	}
+
+	for _, c := range cases {
+		c.chirp.Author = author.Id
+		check := func(_ User, scheduled ScheduledChirp) (Chirp, []MentionCandidate, error) {
+			return scheduled.Chirp, c.candidates, c.checkErr
+		}
+		scheduled, err := db.ScheduleChirp(ScheduledChirp{Chirp: c.chirp, PublishAt: now.Add(time.Hour)})
+		if err != nil {
+			t.Errorf("%s: unable to schedule chirp: %v", c.name, err)
+			continue
+		}
+		chirps, _ := db.GetChirps()
+		for _, chirp := range chirps {
+			if chirp.Body == c.chirp.Body {
+				t.Errorf("%s: scheduled chirp is visible before it is published", c.name)
+			}
+		}
+		if _, err := db.PublishScheduledChirp(scheduled.Id, now, check); !errors.Is(err, ErrScheduledNotFound) {
+			t.Errorf("%s: early publish error == %v, expected %v", c.name, err, ErrScheduledNotFound)
+		}
+		if c.deleteParent {
+			db.DeleteChirp(parent.Id)
+		}
+		if c.block {
+			db.BlockUser(fan.Id, author.Id)
+		}
+
+		chirp, err := db.PublishScheduledChirp(scheduled.Id, now.Add(time.Hour), check)
+		remaining, _ := db.GetScheduledChirps(author.Id)
+		if c.expectedStatus == ScheduledFailed {
+			if err == nil || len(remaining) != 1 || remaining[0].Status != ScheduledFailed || remaining[0].Error != err.Error() {
+				t.Errorf("%s: publish error == %v, remaining == %v, expected a failed scheduled chirp", c.name, err, remaining)
+			}
+			db.CancelScheduledChirp(scheduled.Id, author.Id)
+			continue
+		}
+		if err != nil || len(remaining) != 0 {
+			t.Errorf("%s: publish error == %v, remaining == %v", c.name, err, remaining)
+			continue
+		}
+		timeline, _, _ := db.GetTimeline(follower.Id, 0, 10)
+		if len(timeline) == 0 || timeline[0].Id != chirp.Id {
+			t.Errorf("%s: published chirp %d is not on the follower's timeline", c.name, chirp.Id)
+		}
+		if len(chirp.Mentions) != c.expectedMentions {
+			t.Errorf("%s: mentions == %v, expected %d", c.name, chirp.Mentions, c.expectedMentions)
+		}
+	}
+
+	if count, _ := db.GetTagCount("go"); count != 1 {
+		t.Errorf("tag count == %d, expected 1", count)
+	}
+	// only the chirp published before the block notified the fan
+	groups, _, _ := db.GetNotificationGroups(fan.Id, false, 0, 10)
+	if len(groups) != 1 || groups[0].Type != NotificationMention {
+		t.Errorf("fan notifications == %+v, expected a single mention", groups)
+	}
+}
+
+func TestInsertUserHandle(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+
+	cases := []struct {
+		email     string
+		handle    string
+		expectErr error
+	}{
+		{email: "first@boot.dev", handle: "chirper"},
+		{email: "second@boot.dev", handle: "Chirper", expectErr: ErrHandleTaken},
+		{email: "third@boot.dev", handle: ""},
+		{email: "fourth@boot.dev", handle: ""},
+	}
+
+	for _, c := range cases {
+		usr, err := db.InsertUser(User{Email: c.email, Password: "pwd", Handle: c.handle})
+		if !errors.Is(err, c.expectErr) {
+			t.Errorf("InsertUser(%s) error == %v, expected %v", c.handle, err, c.expectErr)
+			continue
+		}
+		if err == nil && usr.Handle != c.handle {
+			t.Errorf("InsertUser(%s) handle == %s", c.handle, usr.Handle)
+		}
+	}
+	users, _ := db.GetUsers()
+	if len(users) != 3 {
+		t.Errorf("user count == %d, expected 3", len(users))
+	}
+}
+
+func TestIdsNotReused(t *testing.T) {
+	cases := []struct {
+		policy DeletionPolicy
+	}{
+		{policy: DeleteChirps},
+		{policy: AnonymizeChirps},
+	}
+
+	for _, c := range cases {
+		db, err := NewDB(t.TempDir() + "/db.json")
+		if err != nil {
+			t.Fatalf("unable to create db: %s", err)
+		}
+		db.CreateUser("first@boot.dev", "pwd")
+		newest, _ := db.CreateUser("newest@boot.dev", "pwd")
+		chirp, _ := db.CreateChirp("newest chirp", newest.Id)
+
+		if err := db.DeleteUser(newest.Id, c.policy); err != nil {
+			t.Errorf("unable to delete user: %v", err)
+			continue
+		}
+		db.DeleteChirp(chirp.Id)
+
+		usr, _ := db.CreateUser("next@boot.dev", "pwd")
+		if usr.Id <= newest.Id {
+			t.Errorf("policy %v: new user id == %d, expected more than %d", c.policy, usr.Id, newest.Id)
+		}
+		nextChirp, _ := db.CreateChirp("next chirp", usr.Id)
+		if nextChirp.Id <= chirp.Id {
+			t.Errorf("policy %v: new chirp id == %d, expected more than %d", c.policy, nextChirp.Id, chirp.Id)
+		}
+	}
+}
+
+func TestUpdateUserAfterDelete(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := db.CreateUser("gone@boot.dev", "pwd")
+	db.CreateChirp("chirp", usr.Id)
+	db.DeleteUser(usr.Id, DeleteChirps)
+
+	// a request that read the user before the delete must not bring it back
+	if _, err := db.UpdateUser(usr.Id, usr); err == nil {
+		t.Errorf("updating a deleted user succeeded")
+	}
+	if _, err := db.GetUser(usr.Id); err == nil {
+		t.Errorf("user %d exists again after update", usr.Id)
+	}
+	if chirps, _ := db.GetChirps(); len(chirps) != 0 {
+		t.Errorf("chirp count == %d, expected 0", len(chirps))
+	}
+}
+
+func TestUpgradePasswordHash(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := db.CreateUser("usr@boot.dev", "old-hash")
+
+	// a webhook lands between the login's read and its write
+	db.UpdateUser(usr.Id, User{Email: usr.Email, Password: usr.Password, RedStatus: true})
+	if err := db.UpgradePasswordHash(usr.Id, "old-hash", "new-hash"); err != nil {
+		t.Errorf("UpgradePasswordHash error == %v", err)
+	}
+	stored, _ := db.GetUser(usr.Id)
+	if stored.Password != "new-hash" || !stored.RedStatus {
+		t.Errorf("user == %+v, expected the new hash and the webhook's red status", stored)
+	}
+
+	// the password was changed after it was verified
+	if err := db.UpgradePasswordHash(usr.Id, "old-hash", "stale-hash"); !errors.Is(err, ErrPasswordChanged) {
+		t.Errorf("UpgradePasswordHash with a stale hash error == %v, expected %v", err, ErrPasswordChanged)
+	}
+	if stored, _ := db.GetUser(usr.Id); stored.Password != "new-hash" {
+		t.Errorf("password == %s after a stale upgrade, expected new-hash", stored.Password)
+	}
+}
+
+func TestUniqueEmail(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	first, _ := db.CreateUser("first@boot.dev", "pwd")
+	second, _ := db.CreateUser("second@boot.dev", "pwd")
+
+	if _, err := db.CreateUser("First@Boot.dev", "pwd"); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("CreateUser with a taken email error == %v, expected %v", err, ErrEmailTaken)
+	}
+
+	cases := []struct {
+		email     string
+		expectErr error
+	}{
+		{email: "FIRST@boot.dev", expectErr: ErrEmailTaken},
+		{email: "Second@boot.dev"},
+		{email: "renamed@boot.dev"},
+		{email: "first@boot.dev", expectErr: ErrEmailTaken},
+	}
+	for _, c := range cases {
+		update := second
+		update.Email = c.email
+		_, err := db.UpdateUser(second.Id, update)
+		if !errors.Is(err, c.expectErr) {
+			t.Errorf("UpdateUser(%s) error == %v, expected %v", c.email, err, c.expectErr)
+		}
+	}
+
+	// the email of a deleted account can be used again
+	db.DeleteUser(first.Id, AnonymizeChirps)
+	if _, err := db.CreateUser("first@boot.dev", "pwd"); err != nil {
+		t.Errorf("CreateUser with a deleted account's email error == %v", err)
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	admin, _ := db.CreateUser("admin@boot.dev", "pwd")
+	usr, _ := db.CreateUser("usr@boot.dev", "pwd")
+	gone, _ := db.CreateUser("gone@boot.dev", "pwd")
+	db.DeleteUser(gone.Id, AnonymizeChirps)
+
+	cases := []struct {
+		target    int
+		role      string
+		expectErr bool
+		oldRole   string
+	}{
+		{target: usr.Id, role: RoleModerator, oldRole: RoleUser},
+		{target: usr.Id, role: RoleAdmin, oldRole: RoleModerator},
+		{target: usr.Id, role: RoleUser, oldRole: RoleAdmin},
+		{target: usr.Id, role: "owner", expectErr: true},
+		{target: gone.Id, role: RoleModerator, expectErr: true},
+		{target: 99, role: RoleModerator, expectErr: true},
+	}
+
+	expectedChanges := []RoleChange{}
+	for _, c := range cases {
+		updated, err := db.SetUserRole(admin.Id, c.target, c.role)
+		if (err != nil) != c.expectErr {
+			t.Errorf("SetUserRole(%d, %s) error == %v, expected error: %v", c.target, c.role, err, c.expectErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if updated.Role != c.role {
+			t.Errorf("SetUserRole(%d, %s) role == %s", c.target, c.role, updated.Role)
+		}
+		if stored, _ := db.GetUser(c.target); stored.Role != c.role {
+			t.Errorf("SetUserRole(%d, %s) stored role == %s", c.target, c.role, stored.Role)
+		}
+		expectedChanges = append(expectedChanges, RoleChange{ActorId: admin.Id, TargetId: c.target, OldRole: c.oldRole, NewRole: c.role})
+	}
+
+	changes, _ := db.GetRoleChanges()
+	if len(changes) != len(expectedChanges) {
+		t.Fatalf("role change count == %d, expected %d", len(changes), len(expectedChanges))
+	}
+	for i, change := range changes {
+		change.CreatedAt = time.Time{}
+		if change != expectedChanges[i] {
+			t.Errorf("role change %d == %+v, expected %+v", i, change, expectedChanges[i])
+		}
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	owner, _ := db.CreateUser("owner@boot.dev", "pwd")
+	db.CreateUser("other@boot.dev", "pwd")
+
+	cases := []struct {
+		email    string
+		promoted bool
+	}{
+		{email: "nobody@boot.dev", promoted: false},
+		{email: "Owner@boot.dev", promoted: true},
+		// there is an admin now, so nobody else is promoted
+		{email: "other@boot.dev", promoted: false},
+	}
+
+	for _, c := range cases {
+		usr, promoted, err := db.BootstrapAdmin(c.email)
+		if err != nil {
+			t.Errorf("BootstrapAdmin(%s) error == %v", c.email, err)
+			continue
+		}
+		if promoted != c.promoted {
+			t.Errorf("BootstrapAdmin(%s) promoted == %v, expected %v", c.email, promoted, c.promoted)
+		}
+		if promoted && usr.Id != owner.Id {
+			t.Errorf("BootstrapAdmin(%s) promoted user %d, expected %d", c.email, usr.Id, owner.Id)
+		}
+	}
+
+	users, _ := db.GetUsers()
+	for _, usr := range users {
+		if (usr.Role == RoleAdmin) != (usr.Id == owner.Id) {
+			t.Errorf("user %d has role %s", usr.Id, usr.Role)
+		}
+	}
+}
+
+func TestCreateAccessTokenDeletedUser(t *testing.T) {
+	cases := []struct {
+		policy DeletionPolicy
+	}{
+		{policy: DeleteChirps},
+		{policy: AnonymizeChirps},
+	}
+
+	for _, c := range cases {
+		db, err := NewDB(t.TempDir() + "/db.json")
+		if err != nil {
+			t.Fatalf("unable to create db: %s", err)
+		}
+		usr, _ := db.CreateUser("gone@boot.dev", "pwd")
+		db.DeleteUser(usr.Id, c.policy)
+
+		_, err = db.CreateAccessToken(AccessToken{Id: "pat", UserId: usr.Id, Hash: "hash"})
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("policy %v: CreateAccessToken error == %v, expected %v", c.policy, err, ErrUserNotFound)
+		}
+	}
+}
+
+func TestEditChirp(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := db.CreateUser("usr@boot.dev", "pwd")
+	other, _ := db.CreateUser("other@boot.dev", "pwd")
+	chirp, _ := db.InsertChirp(Chirp{Body: "first #go", Author: usr.Id, Tags: []string{"go"}})
+	old, _ := db.InsertChirp(Chirp{Body: "old", Author: usr.Id, CreatedAt: time.Now().UTC().Add(-time.Hour)})
+
+	cases := []struct {
+		id           int
+		author       int
+		body         string
+		tags         []string
+		expectErr    bool
+		expectClosed bool
+	}{
+		{id: chirp.Id, author: usr.Id, body: "second #rust", tags: []string{"rust"}},
+		{id: chirp.Id, author: usr.Id, body: "third #rust", tags: []string{"rust"}},
+		{id: chirp.Id, author: other.Id, body: "hijacked", expectErr: true},
+		{id: old.Id, author: usr.Id, body: "too late", expectErr: true, expectClosed: true},
+	}
+
+	for _, c := range cases {
+		edited, err := db.EditChirp(c.id, c.author, Chirp{Body: c.body, Tags: c.tags}, 15*time.Minute)
+		if (err != nil) != c.expectErr || errors.Is(err, ErrEditWindowClosed) != c.expectClosed {
+			t.Errorf("EditChirp(%d, %s) error == %v, expected error: %v, window closed: %v", c.id, c.body, err, c.expectErr, c.expectClosed)
+			continue
+		}
+		if err == nil && (edited.Body != c.body || !edited.Edited || edited.EditedAt == nil) {
+			t.Errorf("EditChirp(%d, %s) == %+v", c.id, c.body, edited)
+		}
+	}
+
+	revisions, _ := db.GetChirpRevisions(chirp.Id)
+	expected := []string{"first #go", "second #rust"}
+	if len(revisions) != len(expected) {
+		t.Fatalf("revision count == %d, expected %d", len(revisions), len(expected))
+	}
+	for i, revision := range revisions {
+		if revision.Body != expected[i] {
+			t.Errorf("revision %d body == %s, expected %s", i, revision.Body, expected[i])
+		}
+	}
+	if !revisions[0].CreatedAt.Equal(chirp.CreatedAt) || !revisions[1].CreatedAt.Equal(revisions[0].ReplacedAt) {
+		t.Errorf("revision times don't line up: %+v", revisions)
+	}
+	if count, _ := db.GetTagCount("go"); count != 0 {
+		t.Errorf("tag count for go == %d, expected 0", count)
+	}
+	if count, _ := db.GetTagCount("rust"); count != 1 {
+		t.Errorf("tag count for rust == %d, expected 1", count)
+	}
+	if revisions, _ := db.GetChirpRevisions(old.Id); len(revisions) != 0 {
+		t.Errorf("chirp outside the edit window has %d revisions", len(revisions))
+	}
+}
+
+func TestSearchIndexInMemory(t *testing.T) {
+	path := t.TempDir() + "/db.json"
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := db.CreateUser("search@boot.dev", "pwd")
+	db.CreateChirp("indexed in memory", usr.Id)
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "search_index") || strings.Contains(string(data), `"indexed":`) {
+		t.Errorf("search index was written to the database file")
+	}
+
+	// a failed write must not leave its changes in the cached index
+	db.transact(func(structure *DBStructure) error {
+		indexChirp(structure, Chirp{Id: 99, Body: "rolled back"})
+		return errors.New("rolled back")
+	})
+
+	cases := []struct {
+		name  string
+		reset func()
+	}{
+		{name: "cached", reset: func() {}},
+		// what a restart looks like, the index is rebuilt from the chirps
+		{name: "rebuilt", reset: func() {
+			searchIndexesMux.Lock()
+			delete(searchIndexes, path)
+			searchIndexesMux.Unlock()
+		}},
+	}
+
+	for _, c := range cases {
+		c.reset()
+		if hits, _ := db.SearchChirps(SearchQuery{Terms: QueryTerms("memory")}); len(hits) != 1 {
+			t.Errorf("%s: search found %d chirps, expected 1", c.name, len(hits))
+		}
+		if hits, _ := db.SearchChirps(SearchQuery{Terms: QueryTerms("rolled")}); len(hits) != 0 {
+			t.Errorf("%s: search found %d chirps from a failed write", c.name, len(hits))
+		}
+	}
+
+	// a file changed behind the database's back gets a fresh index
+	structure, _ := db.loadDB()
+	structure.Chirps[50] = Chirp{Id: 50, Body: "written elsewhere", Author: usr.Id}
+	data, _ = json.Marshal(structure)
+	os.WriteFile(path, data, 0644)
+	if hits, _ := db.SearchChirps(SearchQuery{Terms: QueryTerms("elsewhere")}); len(hits) != 1 {
+		t.Errorf("search found %d chirps after an outside write, expected 1", len(hits))
+	}
+}
+
+func TestAttachMediaDuplicates(t *testing.T) {
+	db, err := NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := db.CreateUser("usr@boot.dev", "pwd")
+	db.CreateMedia(Media{Id: "a", OwnerId: usr.Id})
+	db.CreateMedia(Media{Id: "b", OwnerId: usr.Id})
+
+	cases := []struct {
+		ids       []string
+		expectErr error
+	}{
+		{ids: []string{"a", "a", "a"}, expectErr: ErrInvalidMedia},
+		{ids: []string{"a", "b", "a"}, expectErr: ErrInvalidMedia},
+		{ids: []string{"a", "b"}},
+	}
+
+	for _, c := range cases {
+		chirp := Chirp{Author: usr.Id, MediaIds: c.ids}
+		scheduled, err := db.ScheduleChirp(ScheduledChirp{Chirp: chirp, PublishAt: time.Now().Add(time.Hour)})
+		if !errors.Is(err, c.expectErr) {
+			t.Errorf("ScheduleChirp(%v) error == %v, expected %v", c.ids, err, c.expectErr)
+		}
+		// the scheduled chirp would keep the media reserved
+		if err == nil {
+			db.CancelScheduledChirp(scheduled.Id, usr.Id)
+		}
+		if _, err := db.InsertChirp(chirp); !errors.Is(err, c.expectErr) {
+			t.Errorf("InsertChirp(%v) error == %v, expected %v", c.ids, err, c.expectErr)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+)
+
+// UserData is every row stored about one user, read in one go for data
+// exports. Secrets such as token values and hashes are included, the
+// caller decides what to leave out.
+type UserData struct {
+	User               User
+	Chirps             []Chirp
+	Revisions          map[int][]ChirpRevision
+	ScheduledChirps    []ScheduledChirp
+	Likes              []Like
+	Following          []Follow
+	Followers          []Follow
+	Blocks             []Restriction
+	Mutes              []Restriction
+	Notifications      []Notification
+	Media              []Media
+	RefreshTokens      []RefreshToken
+	AccessTokens       []AccessToken
+	OAuthClients       []OAuthClient
+	AuthorizationCodes []AuthorizationCode
+	SubscriptionEvents []SubscriptionEvent
+	RoleChanges        []RoleChange
+}
+
+// GetUserData collects everything stored about a user
+func (db *DB) GetUserData(usrId int) (UserData, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return UserData{}, err
+	}
+	usr, ok := dbStruct.Users[usrId]
+	if !ok || usr.Deleted {
+		return UserData{}, fmt.Errorf("user %d not found", usrId)
+	}
+
+	data := UserData{
+		User:               usr,
+		Chirps:             []Chirp{},
+		Revisions:          map[int][]ChirpRevision{},
+		ScheduledChirps:    []ScheduledChirp{},
+		Likes:              []Like{},
+		Following:          []Follow{},
+		Followers:          []Follow{},
+		Blocks:             []Restriction{},
+		Mutes:              []Restriction{},
+		Notifications:      []Notification{},
+		Media:              []Media{},
+		RefreshTokens:      []RefreshToken{},
+		AccessTokens:       []AccessToken{},
+		OAuthClients:       []OAuthClient{},
+		AuthorizationCodes: []AuthorizationCode{},
+		SubscriptionEvents: []SubscriptionEvent{},
+		RoleChanges:        []RoleChange{},
+	}
+	for _, chirp := range dbStruct.Chirps {
+		if chirp.Author != usrId {
+			continue
+		}
+		data.Chirps = append(data.Chirps, chirp)
+		if revisions := dbStruct.ChirpRevisions[chirp.Id]; len(revisions) > 0 {
+			data.Revisions[chirp.Id] = revisions
+		}
+	}
+	sort.Slice(data.Chirps, func(i, j int) bool {
+		return data.Chirps[i].Id < data.Chirps[j].Id
+	})
+	for _, scheduled := range dbStruct.ScheduledChirps {
+		if scheduled.Chirp.Author == usrId {
+			data.ScheduledChirps = append(data.ScheduledChirps, scheduled)
+		}
+	}
+	sortScheduled(data.ScheduledChirps)
+	data.Likes = filterLikes(dbStruct.Likes, func(like Like) bool {
+		return like.UserId == usrId
+	})
+	data.Following = filterFollows(dbStruct.Follows, func(follow Follow) bool {
+		return follow.FollowerId == usrId
+	})
+	data.Followers = filterFollows(dbStruct.Follows, func(follow Follow) bool {
+		return follow.FolloweeId == usrId
+	})
+	ownRestriction := func(restriction Restriction) bool {
+		return restriction.UserId == usrId
+	}
+	data.Blocks = filterRestrictions(dbStruct.Blocks, ownRestriction)
+	data.Mutes = filterRestrictions(dbStruct.Mutes, ownRestriction)
+	data.Notifications = filterNotifications(dbStruct.Notifications, func(notification Notification) bool {
+		return notification.UserId == usrId
+	})
+	for _, media := range dbStruct.Media {
+		if media.OwnerId == usrId {
+			data.Media = append(data.Media, media)
+		}
+	}
+	sort.Slice(data.Media, func(i, j int) bool {
+		return data.Media[i].CreatedAt.Before(data.Media[j].CreatedAt)
+	})
+	for _, token := range dbStruct.RefreshTokens {
+		if token.Id == usrId {
+			data.RefreshTokens = append(data.RefreshTokens, token)
+		}
+	}
+	sort.Slice(data.RefreshTokens, func(i, j int) bool {
+		return data.RefreshTokens[i].Expiration.Before(data.RefreshTokens[j].Expiration)
+	})
+	for _, token := range dbStruct.AccessTokens {
+		if token.UserId == usrId {
+			data.AccessTokens = append(data.AccessTokens, token)
+		}
+	}
+	sort.Slice(data.AccessTokens, func(i, j int) bool {
+		return data.AccessTokens[i].CreatedAt.Before(data.AccessTokens[j].CreatedAt)
+	})
+	for _, client := range dbStruct.OAuthClients {
+		if client.OwnerId == usrId {
+			data.OAuthClients = append(data.OAuthClients, client)
+		}
+	}
+	sort.Slice(data.OAuthClients, func(i, j int) bool {
+		return data.OAuthClients[i].CreatedAt.Before(data.OAuthClients[j].CreatedAt)
+	})
+	for _, code := range dbStruct.AuthorizationCodes {
+		if code.UserId == usrId {
+			data.AuthorizationCodes = append(data.AuthorizationCodes, code)
+		}
+	}
+	sort.Slice(data.AuthorizationCodes, func(i, j int) bool {
+		return data.AuthorizationCodes[i].Expiration.Before(data.AuthorizationCodes[j].Expiration)
+	})
+	for _, event := range dbStruct.SubscriptionEvents {
+		if event.UserId == usrId {
+			data.SubscriptionEvents = append(data.SubscriptionEvents, event)
+		}
+	}
+	for _, change := range dbStruct.RoleChanges {
+		if change.TargetId == usrId {
+			data.RoleChanges = append(data.RoleChanges, change)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TimelineLimit caps how many chirps are kept on each home timeline
+const TimelineLimit = 800
+
+type Follow struct {
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FollowUser makes one user follow another, following twice is a no-op
+func (db *DB) FollowUser(followerId int, followeeId int) error {
+	if followerId == followeeId {
+		return fmt.Errorf("users can't follow themselves")
+	}
+	return db.transact(func(structure *DBStructure) error {
+		for _, id := range []int{followerId, followeeId} {
+			if usr, ok := structure.Users[id]; !ok || usr.Deleted {
+				return fmt.Errorf("user %d not found", id)
+			}
+		}
+		if isBlocked(structure, followerId, followeeId) {
+			return ErrBlocked
+		}
+		for _, follow := range structure.Follows {
+			if follow.FollowerId == followerId && follow.FolloweeId == followeeId {
+				return nil
+			}
+		}
+		structure.Follows = append(structure.Follows, Follow{
+			FollowerId: followerId,
+			FolloweeId: followeeId,
+			CreatedAt:  time.Now().UTC(),
+		})
+		rebuildTimeline(structure, followerId)
+		notify(structure, followeeId, NotificationFollow, followerId, 0)
+		return nil
+	})
+}
+
+// UnfollowUser removes a follow, removing a missing follow is a no-op
+func (db *DB) UnfollowUser(followerId int, followeeId int) error {
+	return db.transact(func(structure *DBStructure) error {
+		if usr, ok := structure.Users[followeeId]; !ok || usr.Deleted {
+			return fmt.Errorf("user %d not found", followeeId)
+		}
+		structure.Follows = filterFollows(structure.Follows, func(follow Follow) bool {
+			return !(follow.FollowerId == followerId && follow.FolloweeId == followeeId)
+		})
+		retractNotification(structure, followeeId, NotificationFollow, followerId, 0)
+		rebuildTimeline(structure, followerId)
+		return nil
+	})
+}
+
+// GetFollows returns every follow in the database
+func (db *DB) GetFollows() ([]Follow, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return append([]Follow{}, dbStruct.Follows...), nil
+}
+
+// GetTimeline returns up to limit chirps from a user's home timeline, newest
+// first, starting after the chirp id before (0 starts at the top). The second
+// value is the cursor for the next page, 0 when there are no more chirps.
+// Only the newest TimelineLimit chirps are on a timeline, paging stops there.
+func (db *DB) GetTimeline(usrId int, before int, limit int) ([]Chirp, int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, 0, err
+	}
+	ids, ok := dbStruct.Timelines[usrId]
+	if !ok {
+		// timelines are only materialized once a user posts or follows
+		ids = timelineIds(&dbStruct, usrId)
+	}
+
+	page := []int{}
+	for _, id := range ids {
+		if before == 0 || id < before {
+			page = append(page, id)
+		}
+	}
+	return pageChirps(&dbStruct, page, limit)
+}
+
+// pageChirps looks up the newest limit chirps among ids and
+// returns them with the cursor for the next page
+func pageChirps(structure *DBStructure, ids []int, limit int) ([]Chirp, int, error) {
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	chirps := []Chirp{}
+	next := 0
+	for _, id := range ids {
+		chirp, ok := structure.Chirps[id]
+		if !ok {
+			continue
+		}
+		if len(chirps) == limit {
+			next = chirps[len(chirps)-1].Id
+			break
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, next, nil
+}
+
+// Timelines are fanned out on write: every new chirp is pushed onto the
+// stored timeline of its author and each of their followers, so reading a
+// timeline is a single lookup instead of a merge over everyone followed.
+
+// fanOut adds a new chirp to the timelines of its author and their followers
+func fanOut(structure *DBStructure, chirp Chirp) {
+	recipients := []int{chirp.Author}
+	for _, follow := range structure.Follows {
+		if follow.FolloweeId == chirp.Author {
+			recipients = append(recipients, follow.FollowerId)
+		}
+	}
+	for _, usrId := range recipients {
+		ids, ok := structure.Timelines[usrId]
+		if !ok {
+			rebuildTimeline(structure, usrId)
+			continue
+		}
+		// chirp ids only grow, so the new chirp always goes on top
+		ids = append([]int{chirp.Id}, ids...)
+		if len(ids) > TimelineLimit {
+			ids = ids[:TimelineLimit]
+		}
+		structure.Timelines[usrId] = ids
+	}
+}
+
+// rebuildTimeline recomputes a user's stored timeline from scratch, it is
+// used when the set of accounts they follow changes
+func rebuildTimeline(structure *DBStructure, usrId int) {
+	structure.Timelines[usrId] = timelineIds(structure, usrId)
+}
+
+func timelineIds(structure *DBStructure, usrId int) []int {
+	authors := map[int]bool{usrId: true}
+	for _, follow := range structure.Follows {
+		if follow.FollowerId == usrId {
+			authors[follow.FolloweeId] = true
+		}
+	}
+	ids := []int{}
+	for id, chirp := range structure.Chirps {
+		if authors[chirp.Author] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	if len(ids) > TimelineLimit {
+		ids = ids[:TimelineLimit]
+	}
+	return ids
+}
+
+// removeFromTimelines drops a deleted chirp from every stored timeline
+func removeFromTimelines(structure *DBStructure, chirpId int) {
+	for usrId, ids := range structure.Timelines {
+		kept := make([]int, 0, len(ids))
+		for _, id := range ids {
+			if id != chirpId {
+				kept = append(kept, id)
+			}
+		}
+		structure.Timelines[usrId] = kept
+	}
+}
+
+func filterFollows(follows []Follow, keep func(follow Follow) bool) []Follow {
+	kept := []Follow{}
+	for _, follow := range follows {
+		if keep(follow) {
+			kept = append(kept, follow)
+		}
+	}
+	return kept
+}
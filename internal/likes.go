@@ -0,0 +1,64 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+type Like struct {
+	ChirpId   int       `json:"chirp_id"`
+	UserId    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikeChirp records that a user likes a chirp, liking twice is a no-op
+func (db *DB) LikeChirp(usrId int, chirpId int) error {
+	return db.transact(func(structure *DBStructure) error {
+		chirp, ok := structure.Chirps[chirpId]
+		if !ok {
+			return fmt.Errorf("chirp not found")
+		}
+		for _, like := range structure.Likes {
+			if like.ChirpId == chirpId && like.UserId == usrId {
+				return nil
+			}
+		}
+		structure.Likes = append(structure.Likes, Like{ChirpId: chirpId, UserId: usrId, CreatedAt: time.Now().UTC()})
+		notify(structure, chirp.Author, NotificationLike, usrId, chirpId)
+		return nil
+	})
+}
+
+// UnlikeChirp removes a user's like, removing a missing like is a no-op
+func (db *DB) UnlikeChirp(usrId int, chirpId int) error {
+	return db.transact(func(structure *DBStructure) error {
+		chirp, ok := structure.Chirps[chirpId]
+		if !ok {
+			return fmt.Errorf("chirp not found")
+		}
+		retractNotification(structure, chirp.Author, NotificationLike, usrId, chirpId)
+		structure.Likes = filterLikes(structure.Likes, func(like Like) bool {
+			return !(like.ChirpId == chirpId && like.UserId == usrId)
+		})
+		return nil
+	})
+}
+
+// GetLikes returns every like in the database
+func (db *DB) GetLikes() ([]Like, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return append([]Like{}, dbStruct.Likes...), nil
+}
+
+func filterLikes(likes []Like, keep func(like Like) bool) []Like {
+	kept := []Like{}
+	for _, like := range likes {
+		if keep(like) {
+			kept = append(kept, like)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidMedia = errors.New("invalid media")
+
+// MaxChirpMedia is how many attachments a single chirp may carry
+const MaxChirpMedia = 4
+
+// MediaVariant is one re-encoded size of an upload, the bytes live in a
+// blob store under Key. Keys are content hashes, so uploads of the same
+// image share their blobs.
+type MediaVariant struct {
+	Key         string `json:"key"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+// Media is an uploaded attachment, Variants are keyed by name such as
+// "thumb" or "display"
+type Media struct {
+	Id        string                  `json:"id"`
+	OwnerId   int                     `json:"owner_id"`
+	Variants  map[string]MediaVariant `json:"variants"`
+	AltText   string                  `json:"alt_text"`
+	CreatedAt time.Time               `json:"created_at"`
+	// ChirpId is 0 until the media is attached to a chirp
+	ChirpId int `json:"chirp_id"`
+}
+
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	media.CreatedAt = time.Now().UTC()
+	err := db.transact(func(structure *DBStructure) error {
+		if _, ok := structure.Media[media.Id]; ok {
+			return fmt.Errorf("media %s already exists", media.Id)
+		}
+		structure.Media[media.Id] = media
+		return nil
+	})
+	if err != nil {
+		return Media{}, err
+	}
+	return media, nil
+}
+
+func (db *DB) GetMedia(id string) (Media, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Media{}, err
+	}
+	media, ok := dbStruct.Media[id]
+	if !ok {
+		return Media{}, fmt.Errorf("media not found")
+	}
+	return media, nil
+}
+
+// GetAllMedia returns every attachment in the database keyed by id
+func (db *DB) GetAllMedia() (map[string]Media, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return dbStruct.Media, nil
+}
+
+// attachMedia checks every id belongs to the author and isn't attached to
+// another chirp or reserved by a scheduled one yet, then attaches them to
+// the chirp
+func attachMedia(structure *DBStructure, chirp Chirp) error {
+	if err := checkDuplicateMedia(chirp.MediaIds); err != nil {
+		return err
+	}
+	if len(chirp.MediaIds) > MaxChirpMedia {
+		return fmt.Errorf("%w: a chirp can have at most %d attachments", ErrInvalidMedia, MaxChirpMedia)
+	}
+	reserved := reservedMedia(structure, 0)
+	for _, id := range chirp.MediaIds {
+		media, ok := structure.Media[id]
+		if !ok || media.OwnerId != chirp.Author || media.ChirpId != 0 || reserved[id] {
+			return fmt.Errorf("%w: media %s not found", ErrInvalidMedia, id)
+		}
+	}
+	for _, id := range chirp.MediaIds {
+		media := structure.Media[id]
+		media.ChirpId = chirp.Id
+		structure.Media[id] = media
+	}
+	return nil
+}
+
+// checkDuplicateMedia rejects a media id listed more than once, which would
+// count against the attachment limit and render the same media twice
+func checkDuplicateMedia(ids []string) error {
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("%w: media %s is attached more than once", ErrInvalidMedia, id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// ExpireUnattachedMedia deletes uploads that were never attached to a chirp
+// and are older than maxAge, media waiting on a scheduled chirp are kept
+func (db *DB) ExpireUnattachedMedia(maxAge time.Duration) error {
+	cutoff := time.Now().UTC().Add(-maxAge)
+	return db.transact(func(structure *DBStructure) error {
+		reserved := reservedMedia(structure, 0)
+		deleteMedia(structure, func(media Media) bool {
+			return media.ChirpId == 0 && !reserved[media.Id] && media.CreatedAt.Before(cutoff)
+		})
+		return nil
+	})
+}
+
+// TakeOrphanedBlobs returns the blob keys of deleted media and forgets them,
+// the caller is expected to remove them from the blob store. Keys that other
+// media still use are left out.
+func (db *DB) TakeOrphanedBlobs() ([]string, error) {
+	keys := []string{}
+	err := db.transact(func(structure *DBStructure) error {
+		skip := map[string]bool{}
+		for _, media := range structure.Media {
+			for _, variant := range media.Variants {
+				skip[variant.Key] = true
+			}
+		}
+		for _, key := range structure.OrphanedBlobs {
+			if !skip[key] {
+				skip[key] = true
+				keys = append(keys, key)
+			}
+		}
+		structure.OrphanedBlobs = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// deleteMedia removes the media records matching remove and queues their
+// blobs for deletion
+func deleteMedia(structure *DBStructure, remove func(media Media) bool) {
+	for id, media := range structure.Media {
+		if !remove(media) {
+			continue
+		}
+		for _, variant := range media.Variants {
+			structure.OrphanedBlobs = append(structure.OrphanedBlobs, variant.Key)
+		}
+		delete(structure.Media, id)
+	}
+}
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	NotificationMention    = "mention"
+	NotificationReply      = "reply"
+	NotificationLike       = "like"
+	NotificationFollow     = "follow"
+	NotificationRedUpgrade = "red_upgrade"
+)
+
+// aggregatedNotifications are the types where repeated events about the same
+// chirp are shown as one entry, "5 people liked your chirp"
+var aggregatedNotifications = map[string]bool{
+	NotificationLike:   true,
+	NotificationFollow: true,
+}
+
+// Mention is an @handle in a chirp body that resolved to a user, Start and
+// End are offsets in runes into the body and cover the leading @
+type Mention struct {
+	UserId int `json:"user_id"`
+	Start  int `json:"start"`
+	End    int `json:"end"`
+}
+
+// MentionCandidate is an @handle found in a chirp body that hasn't been
+// looked up yet, Start and End are offsets as in Mention
+type MentionCandidate struct {
+	Handle string
+	Start  int
+	End    int
+}
+
+// Notification tells a user that someone interacted with them, ActorId and
+// ChirpId are 0 for events that have no actor or chirp
+type Notification struct {
+	Id        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	Type      string    `json:"type"`
+	ActorId   int       `json:"actor_id"`
+	ChirpId   int       `json:"chirp_id"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NotificationGroup is one entry in a user's inbox, either a single
+// notification or repeated events of an aggregated type
+type NotificationGroup struct {
+	// Id is the id of the newest notification in the group
+	Id      int    `json:"id"`
+	Type    string `json:"type"`
+	ChirpId int    `json:"chirp_id,omitempty"`
+	// ActorIds are newest first
+	ActorIds  []int     `json:"actor_ids"`
+	Count     int       `json:"count"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type groupKey struct {
+	Type    string
+	ChirpId int
+	Read    bool
+}
+
+func keyFor(notification Notification) (groupKey, bool) {
+	if !aggregatedNotifications[notification.Type] {
+		return groupKey{}, false
+	}
+	return groupKey{Type: notification.Type, ChirpId: notification.ChirpId, Read: notification.Read}, true
+}
+
+// CreateNotification records a notification for a user
+func (db *DB) CreateNotification(notification Notification) error {
+	return db.transact(func(structure *DBStructure) error {
+		if usr, ok := structure.Users[notification.UserId]; !ok || usr.Deleted {
+			return fmt.Errorf("user %d not found", notification.UserId)
+		}
+		addNotification(structure, notification)
+		return nil
+	})
+}
+
+// GetNotificationGroups returns up to limit inbox entries for a user, newest
+// first, starting after the entry id before (0 starts at the top). The second
+// value is the cursor for the next page, 0 when there are no more entries.
+func (db *DB) GetNotificationGroups(usrId int, unreadOnly bool, before int, limit int) ([]NotificationGroup, int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, 0, err
+	}
+	groups := groupNotifications(dbStruct.Notifications, usrId)
+
+	page := []NotificationGroup{}
+	next := 0
+	for _, group := range groups {
+		if (before != 0 && group.Id >= before) || (unreadOnly && group.Read) {
+			continue
+		}
+		if len(page) == limit {
+			next = page[len(page)-1].Id
+			break
+		}
+		page = append(page, group)
+	}
+	return page, next, nil
+}
+
+// CountUnreadNotifications counts unread inbox entries, an aggregated
+// entry counts once
+func (db *DB) CountUnreadNotifications(usrId int) (int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, group := range groupNotifications(dbStruct.Notifications, usrId) {
+		if !group.Read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// MarkNotificationRead marks an inbox entry as read, for an aggregated entry
+// that is every notification in the group
+func (db *DB) MarkNotificationRead(usrId int, id int) error {
+	return db.transact(func(structure *DBStructure) error {
+		var target *Notification
+		for i := range structure.Notifications {
+			if structure.Notifications[i].Id == id && structure.Notifications[i].UserId == usrId {
+				target = &structure.Notifications[i]
+			}
+		}
+		if target == nil {
+			return fmt.Errorf("notification not found")
+		}
+		targetKey, aggregated := keyFor(*target)
+		target.Read = true
+		if !aggregated {
+			return nil
+		}
+		for i, notification := range structure.Notifications {
+			if key, _ := keyFor(notification); notification.UserId == usrId && key == targetKey {
+				structure.Notifications[i].Read = true
+			}
+		}
+		return nil
+	})
+}
+
+// MarkAllNotificationsRead marks every notification of a user as read
+func (db *DB) MarkAllNotificationsRead(usrId int) error {
+	return db.transact(func(structure *DBStructure) error {
+		for i, notification := range structure.Notifications {
+			if notification.UserId == usrId {
+				structure.Notifications[i].Read = true
+			}
+		}
+		return nil
+	})
+}
+
+// groupNotifications builds a user's inbox, newest first
+func groupNotifications(notifications []Notification, usrId int) []NotificationGroup {
+	mine := filterNotifications(notifications, func(notification Notification) bool {
+		return notification.UserId == usrId
+	})
+	sort.Slice(mine, func(i, j int) bool {
+		return mine[i].Id > mine[j].Id
+	})
+
+	groups := []NotificationGroup{}
+	groupIndex := map[groupKey]int{}
+	for _, notification := range mine {
+		key, aggregated := keyFor(notification)
+		if i, ok := groupIndex[key]; aggregated && ok {
+			// read notifications aren't retracted, so an actor who undid
+			// and redid an action has two rows but is counted once
+			if !slices.Contains(groups[i].ActorIds, notification.ActorId) {
+				groups[i].ActorIds = append(groups[i].ActorIds, notification.ActorId)
+				groups[i].Count++
+			}
+			continue
+		}
+		group := NotificationGroup{
+			Id:        notification.Id,
+			Type:      notification.Type,
+			ChirpId:   notification.ChirpId,
+			ActorIds:  []int{},
+			Count:     1,
+			Read:      notification.Read,
+			CreatedAt: notification.CreatedAt,
+		}
+		if notification.ActorId != 0 {
+			group.ActorIds = append(group.ActorIds, notification.ActorId)
+		}
+		if aggregated {
+			groupIndex[key] = len(groups)
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// notifyReply notifies the author of the chirp being replied to
+func notifyReply(structure *DBStructure, chirp Chirp) {
+	if parent, ok := structure.Chirps[chirp.ReplyTo]; ok && chirp.ReplyTo != 0 {
+		notify(structure, parent.Author, NotificationReply, chirp.Author, chirp.Id)
+	}
+}
+
+// ResolveMentions turns candidates into mentions of existing users, handles
+// that don't exist or belong to someone in a block with the author are left
+// as plain text
+func (db *DB) ResolveMentions(authorId int, candidates []MentionCandidate) ([]Mention, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return resolveMentions(&dbStruct, authorId, candidates), nil
+}
+
+func resolveMentions(structure *DBStructure, authorId int, candidates []MentionCandidate) []Mention {
+	byHandle := map[string]int{}
+	for _, usr := range structure.Users {
+		if !usr.Deleted && usr.Handle != "" {
+			byHandle[strings.ToLower(usr.Handle)] = usr.Id
+		}
+	}
+	mentions := []Mention{}
+	for _, candidate := range candidates {
+		usrId, ok := byHandle[strings.ToLower(candidate.Handle)]
+		if !ok || isBlocked(structure, authorId, usrId) {
+			continue
+		}
+		mentions = append(mentions, Mention{UserId: usrId, Start: candidate.Start, End: candidate.End})
+	}
+	return mentions
+}
+
+// notifyMentions notifies each user mentioned in a chirp who wasn't already
+// mentioned before an edit, the author of a chirp being replied to hears
+// about the reply instead
+func notifyMentions(structure *DBStructure, chirp Chirp, previous []Mention) {
+	notified := map[int]bool{}
+	for _, mention := range previous {
+		notified[mention.UserId] = true
+	}
+	if parent, ok := structure.Chirps[chirp.ReplyTo]; ok && chirp.ReplyTo != 0 {
+		notified[parent.Author] = true
+	}
+	for _, mention := range chirp.Mentions {
+		if notified[mention.UserId] {
+			continue
+		}
+		notified[mention.UserId] = true
+		notify(structure, mention.UserId, NotificationMention, chirp.Author, chirp.Id)
+	}
+}
+
+// notify adds a notification unless the user is acting on their own content
+// or has blocked or muted the actor
+func notify(structure *DBStructure, usrId int, kind string, actorId int, chirpId int) {
+	if usrId == actorId || isBlocked(structure, usrId, actorId) || hasMuted(structure, usrId, actorId) {
+		return
+	}
+	addNotification(structure, Notification{
+		UserId:  usrId,
+		Type:    kind,
+		ActorId: actorId,
+		ChirpId: chirpId,
+	})
+}
+
+// retractNotification removes an unread notification when the action behind
+// it is undone, such as an unlike or an unfollow
+func retractNotification(structure *DBStructure, usrId int, kind string, actorId int, chirpId int) {
+	structure.Notifications = filterNotifications(structure.Notifications, func(notification Notification) bool {
+		return notification.Read || notification.UserId != usrId || notification.Type != kind ||
+			notification.ActorId != actorId || notification.ChirpId != chirpId
+	})
+}
+
+func addNotification(structure *DBStructure, notification Notification) {
+	structure.LastNotificationId++
+	notification.Id = structure.LastNotificationId
+	notification.CreatedAt = time.Now().UTC()
+	structure.Notifications = append(structure.Notifications, notification)
+}
+
+func hasMuted(structure *DBStructure, usrId int, targetId int) bool {
+	for _, mute := range structure.Mutes {
+		if mute.UserId == usrId && mute.TargetId == targetId {
+			return true
+		}
+	}
+	return false
+}
+
+func filterNotifications(notifications []Notification, keep func(notification Notification) bool) []Notification {
+	kept := []Notification{}
+	for _, notification := range notifications {
+		if keep(notification) {
+			kept = append(kept, notification)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// OAuthClient is a third-party application registered to use chirpy as an OAuth2 provider
+type OAuthClient struct {
+	Id           string    `json:"id"`
+	OwnerId      int       `json:"owner_id"`
+	Name         string    `json:"name"`
+	SecretHash   string    `json:"secret_hash"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuthorizationCode is a single-use code handed out by the consent page,
+// stored under the hash of the code itself
+type AuthorizationCode struct {
+	Hash                string    `json:"hash"`
+	ClientId            string    `json:"client_id"`
+	UserId              int       `json:"user_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	Scopes              []string  `json:"scopes"`
+	CodeChallenge       string    `json:"code_challenge"`
+	CodeChallengeMethod string    `json:"code_challenge_method"`
+	Expiration          time.Time `json:"expiration"`
+}
+
+// CreateOAuthClient registers a new OAuth2 client
+func (db *DB) CreateOAuthClient(client OAuthClient) (OAuthClient, error) {
+	err := db.transact(func(structure *DBStructure) error {
+		if _, ok := structure.OAuthClients[client.Id]; ok {
+			return fmt.Errorf("client %s already exists", client.Id)
+		}
+		structure.OAuthClients[client.Id] = client
+		return nil
+	})
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	return client, nil
+}
+
+// GetOAuthClient returns a registered OAuth2 client by id
+func (db *DB) GetOAuthClient(clientId string) (OAuthClient, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	client, ok := dbStruct.OAuthClients[clientId]
+	if !ok {
+		return OAuthClient{}, fmt.Errorf("client %s not found", clientId)
+	}
+	return client, nil
+}
+
+// CreateAuthorizationCode stores a freshly issued authorization code
+func (db *DB) CreateAuthorizationCode(code AuthorizationCode) error {
+	return db.transact(func(structure *DBStructure) error {
+		structure.AuthorizationCodes[code.Hash] = code
+		return nil
+	})
+}
+
+// ConsumeAuthorizationCode looks up and deletes an authorization code in one
+// step so that a code can never be exchanged twice
+func (db *DB) ConsumeAuthorizationCode(hash string) (AuthorizationCode, error) {
+	code := AuthorizationCode{}
+	err := db.transact(func(structure *DBStructure) error {
+		found, ok := structure.AuthorizationCodes[hash]
+		if !ok {
+			return fmt.Errorf("authorization code not found")
+		}
+		delete(structure.AuthorizationCodes, hash)
+		for otherHash, other := range structure.AuthorizationCodes {
+			if time.Now().After(other.Expiration) {
+				delete(structure.AuthorizationCodes, otherHash)
+			}
+		}
+		code = found
+		return nil
+	})
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+	if time.Now().After(code.Expiration) {
+		return AuthorizationCode{}, fmt.Errorf("authorization code expired")
+	}
+	return code, nil
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrEditWindowClosed = errors.New("edit window closed")
+
+// ChirpRevision is an earlier version of an edited chirp
+type ChirpRevision struct {
+	Body       string      `json:"body"`
+	Moderation *Moderation `json:"moderation,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	ReplacedAt time.Time   `json:"replaced_at"`
+}
+
+// EditChirp replaces the body of a chirp owned by author, along with the tags,
+// mentions and moderation derived from it, and keeps the previous version in
+// the chirp's revision history. Chirps can only be edited for window after
+// they were created, checked in the same write as the edit.
+func (db *DB) EditChirp(id int, author int, update Chirp, window time.Duration) (Chirp, error) {
+	edited := Chirp{}
+	err := db.transact(func(structure *DBStructure) error {
+		chirp, ok := structure.Chirps[id]
+		if !ok || chirp.Author != author {
+			return fmt.Errorf("chirp not found")
+		}
+		now := time.Now().UTC()
+		if chirp.CreatedAt.IsZero() || now.Sub(chirp.CreatedAt) > window {
+			return ErrEditWindowClosed
+		}
+
+		versionCreated := chirp.CreatedAt
+		if chirp.EditedAt != nil {
+			versionCreated = *chirp.EditedAt
+		}
+		structure.ChirpRevisions[id] = append(structure.ChirpRevisions[id], ChirpRevision{
+			Body:       chirp.Body,
+			Moderation: chirp.Moderation,
+			CreatedAt:  versionCreated,
+			ReplacedAt: now,
+		})
+
+		countTags(structure, chirp.Tags, -1)
+		countTags(structure, update.Tags, 1)
+		unindexChirp(structure, chirp)
+		previousMentions := chirp.Mentions
+		chirp.Body = update.Body
+		chirp.Tags = update.Tags
+		chirp.Mentions = update.Mentions
+		chirp.Moderation = update.Moderation
+		chirp.Edited = true
+		chirp.EditedAt = &now
+		structure.Chirps[id] = chirp
+		indexChirp(structure, chirp)
+		notifyMentions(structure, chirp, previousMentions)
+		edited = chirp
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return edited, nil
+}
+
+// GetChirpRevisions returns the earlier versions of a chirp, oldest first
+func (db *DB) GetChirpRevisions(id int) ([]ChirpRevision, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := dbStruct.Chirps[id]; !ok {
+		return nil, fmt.Errorf("chirp not found")
+	}
+	return append([]ChirpRevision{}, dbStruct.ChirpRevisions[id]...), nil
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// RoleChange is one entry in the audit trail of role grants and revocations
+type RoleChange struct {
+	ActorId   int       `json:"actor_id"`
+	TargetId  int       `json:"target_id"`
+	OldRole   string    `json:"old_role"`
+	NewRole   string    `json:"new_role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RoleRank orders roles so that a higher role includes the lower ones,
+// unknown roles rank as a plain user
+func RoleRank(role string) int {
+	switch role {
+	case RoleAdmin:
+		return 2
+	case RoleModerator:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+// SetUserRole changes a user's role and records who made the change
+func (db *DB) SetUserRole(actorId int, targetId int, role string) (User, error) {
+	if !IsValidRole(role) {
+		return User{}, fmt.Errorf("unknown role %s", role)
+	}
+
+	updatedUser := User{}
+	err := db.transact(func(structure *DBStructure) error {
+		usr, ok := structure.Users[targetId]
+		if !ok || usr.Deleted {
+			return fmt.Errorf("user %d not found", targetId)
+		}
+
+		oldRole := usr.Role
+		if oldRole == "" {
+			oldRole = RoleUser
+		}
+		usr.Role = role
+		structure.Users[targetId] = usr
+		structure.RoleChanges = append(structure.RoleChanges, RoleChange{
+			ActorId:   actorId,
+			TargetId:  targetId,
+			OldRole:   oldRole,
+			NewRole:   role,
+			CreatedAt: time.Now().UTC(),
+		})
+		updatedUser = usr
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return updatedUser, nil
+}
+
+// GetRoleChanges returns the full role audit trail, oldest first
+func (db *DB) GetRoleChanges() ([]RoleChange, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return append([]RoleChange{}, dbStruct.RoleChanges...), nil
+}
+
+// BootstrapAdmin promotes the one account signing in with email to admin,
+// but only while nobody is an admin yet. It returns false when there was
+// nothing to promote.
+func (db *DB) BootstrapAdmin(email string) (User, bool, error) {
+	promoted := User{}
+	err := db.transact(func(structure *DBStructure) error {
+		matches := []User{}
+		for _, usr := range structure.Users {
+			if usr.Role == RoleAdmin {
+				return nil
+			}
+			if !usr.Deleted && strings.EqualFold(usr.Email, email) {
+				matches = append(matches, usr)
+			}
+		}
+		if len(matches) == 0 {
+			return nil
+		}
+		if len(matches) > 1 {
+			return fmt.Errorf("%d accounts use %s", len(matches), email)
+		}
+
+		promoted = matches[0]
+		oldRole := promoted.Role
+		if oldRole == "" {
+			oldRole = RoleUser
+		}
+		promoted.Role = RoleAdmin
+		structure.Users[promoted.Id] = promoted
+		structure.RoleChanges = append(structure.RoleChanges, RoleChange{
+			TargetId:  promoted.Id,
+			OldRole:   oldRole,
+			NewRole:   RoleAdmin,
+			CreatedAt: time.Now().UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		return User{}, false, err
+	}
+	return promoted, promoted.Id != 0, nil
+}
@@ -0,0 +1,256 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrScheduledNotFound = errors.New("scheduled chirp not found")
+
+const (
+	ScheduledPending = "pending"
+	// ScheduledFailed chirps could not be published, for example because the
+	// chirp they reply to was deleted, and wait for the author to edit or
+	// cancel them
+	ScheduledFailed = "failed"
+)
+
+// ScheduledChirp is a chirp waiting to be published at PublishAt. Until then
+// it lives outside the chirps table, so readers never see it and it has no
+// chirp id, no tag counts, no index entries and no notifications.
+type ScheduledChirp struct {
+	Id    int   `json:"id"`
+	Chirp Chirp `json:"chirp"`
+	// OriginalBody is the body as the author wrote it, before the content
+	// filters ran, so they can run again when the chirp is published
+	OriginalBody string    `json:"original_body"`
+	PublishAt    time.Time `json:"publish_at"`
+	Status       string    `json:"status"`
+	// Error explains why a failed chirp couldn't be published
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ScheduleChirp stores a chirp to be published later, its media are reserved
+// so they can't be attached elsewhere or expire in the meantime
+func (db *DB) ScheduleChirp(scheduled ScheduledChirp) (ScheduledChirp, error) {
+	scheduled.Status = ScheduledPending
+	scheduled.CreatedAt = time.Now().UTC()
+	err := db.transact(func(structure *DBStructure) error {
+		if err := checkScheduledMedia(structure, scheduled); err != nil {
+			return err
+		}
+		structure.LastScheduledId++
+		scheduled.Id = structure.LastScheduledId
+		structure.ScheduledChirps[scheduled.Id] = scheduled
+		return nil
+	})
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	return scheduled, nil
+}
+
+// GetScheduledChirps returns an author's scheduled chirps, the next one to
+// be published first
+func (db *DB) GetScheduledChirps(author int) ([]ScheduledChirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	scheduled := []ScheduledChirp{}
+	for _, item := range dbStruct.ScheduledChirps {
+		if item.Chirp.Author == author {
+			scheduled = append(scheduled, item)
+		}
+	}
+	sortScheduled(scheduled)
+	return scheduled, nil
+}
+
+// GetScheduledChirp returns one of an author's scheduled chirps
+func (db *DB) GetScheduledChirp(id int, author int) (ScheduledChirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	scheduled, ok := dbStruct.ScheduledChirps[id]
+	if !ok || scheduled.Chirp.Author != author {
+		return ScheduledChirp{}, ErrScheduledNotFound
+	}
+	return scheduled, nil
+}
+
+// UpdateScheduledChirp replaces the content and publish time of a scheduled
+// chirp, a failed chirp goes back to pending
+func (db *DB) UpdateScheduledChirp(id int, author int, update ScheduledChirp) (ScheduledChirp, error) {
+	scheduled := ScheduledChirp{}
+	err := db.transact(func(structure *DBStructure) error {
+		existing, ok := structure.ScheduledChirps[id]
+		if !ok || existing.Chirp.Author != author {
+			return ErrScheduledNotFound
+		}
+		scheduled = existing
+		scheduled.Chirp = update.Chirp
+		scheduled.Chirp.Author = author
+		scheduled.OriginalBody = update.OriginalBody
+		scheduled.PublishAt = update.PublishAt
+		scheduled.Status = ScheduledPending
+		scheduled.Error = ""
+		if err := checkScheduledMedia(structure, scheduled); err != nil {
+			return err
+		}
+		structure.ScheduledChirps[id] = scheduled
+		return nil
+	})
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	return scheduled, nil
+}
+
+// CancelScheduledChirp deletes a scheduled chirp, its media stay uploaded
+// and can be attached to another chirp
+func (db *DB) CancelScheduledChirp(id int, author int) error {
+	return db.transact(func(structure *DBStructure) error {
+		scheduled, ok := structure.ScheduledChirps[id]
+		if !ok || scheduled.Chirp.Author != author {
+			return ErrScheduledNotFound
+		}
+		delete(structure.ScheduledChirps, id)
+		return nil
+	})
+}
+
+// GetDueScheduledChirps returns the pending chirps whose publish time has
+// come, the longest overdue first
+func (db *DB) GetDueScheduledChirps(now time.Time) ([]ScheduledChirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	due := []ScheduledChirp{}
+	for _, scheduled := range dbStruct.ScheduledChirps {
+		if scheduled.Status == ScheduledPending && !scheduled.PublishAt.After(now) {
+			due = append(due, scheduled)
+		}
+	}
+	sortScheduled(due)
+	return due, nil
+}
+
+// PublishCheck runs on a scheduled chirp in the write that publishes it and
+// returns the chirp to publish with the @handles in its body, or an error
+// explaining why it can't be
+type PublishCheck func(author User, scheduled ScheduledChirp) (Chirp, []MentionCandidate, error)
+
+// PublishScheduledChirp turns a scheduled chirp that is due at now into a
+// real one after passing it through check. Its mentions are resolved again
+// from the handles check returns, so users who blocked the author since the
+// chirp was scheduled aren't mentioned. When publishing isn't possible
+// anymore the scheduled chirp is marked as failed with the reason, and the
+// reason is returned as the error.
+func (db *DB) PublishScheduledChirp(id int, now time.Time, check PublishCheck) (Chirp, error) {
+	var chirp Chirp
+	var publishErr error
+	err := db.transact(func(structure *DBStructure) error {
+		scheduled, ok := structure.ScheduledChirps[id]
+		if !ok || scheduled.Status != ScheduledPending || scheduled.PublishAt.After(now) {
+			return ErrScheduledNotFound
+		}
+		// removed first so its media are no longer reserved when attached
+		delete(structure.ScheduledChirps, id)
+
+		draft := scheduled.Chirp
+		publishErr = checkCanPublish(structure, draft)
+		if publishErr == nil {
+			var candidates []MentionCandidate
+			draft, candidates, publishErr = check(structure.Users[scheduled.Chirp.Author], scheduled)
+			draft.Author = scheduled.Chirp.Author
+			draft.Mentions = resolveMentions(structure, draft.Author, candidates)
+		}
+		if publishErr == nil {
+			draft.CreatedAt = now
+			chirp, publishErr = insertChirp(structure, draft)
+		}
+		if publishErr != nil {
+			scheduled.Status = ScheduledFailed
+			scheduled.Error = publishErr.Error()
+			structure.ScheduledChirps[id] = scheduled
+		}
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	if publishErr != nil {
+		return Chirp{}, publishErr
+	}
+	return chirp, nil
+}
+
+// checkCanPublish repeats the checks made when the chirp was scheduled
+// that may no longer hold
+func checkCanPublish(structure *DBStructure, chirp Chirp) error {
+	if usr, ok := structure.Users[chirp.Author]; !ok || usr.Deleted {
+		return fmt.Errorf("author not found")
+	}
+	for _, related := range []int{chirp.ReplyTo, chirp.QuoteOf} {
+		if related == 0 {
+			continue
+		}
+		other, ok := structure.Chirps[related]
+		if !ok {
+			return fmt.Errorf("the chirp being replied to or quoted was deleted")
+		}
+		if isBlocked(structure, chirp.Author, other.Author) {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+// checkScheduledMedia checks a scheduled chirp's media belong to its author
+// and aren't attached to or reserved by another chirp
+func checkScheduledMedia(structure *DBStructure, scheduled ScheduledChirp) error {
+	if err := checkDuplicateMedia(scheduled.Chirp.MediaIds); err != nil {
+		return err
+	}
+	if len(scheduled.Chirp.MediaIds) > MaxChirpMedia {
+		return fmt.Errorf("%w: a chirp can have at most %d attachments", ErrInvalidMedia, MaxChirpMedia)
+	}
+	reserved := reservedMedia(structure, scheduled.Id)
+	for _, id := range scheduled.Chirp.MediaIds {
+		media, ok := structure.Media[id]
+		if !ok || media.OwnerId != scheduled.Chirp.Author || media.ChirpId != 0 || reserved[id] {
+			return fmt.Errorf("%w: media %s not found", ErrInvalidMedia, id)
+		}
+	}
+	return nil
+}
+
+// reservedMedia returns the ids of media held by scheduled chirps other than
+// except
+func reservedMedia(structure *DBStructure, except int) map[string]bool {
+	reserved := map[string]bool{}
+	for _, scheduled := range structure.ScheduledChirps {
+		if scheduled.Id == except {
+			continue
+		}
+		for _, id := range scheduled.Chirp.MediaIds {
+			reserved[id] = true
+		}
+	}
+	return reserved
+}
+
+func sortScheduled(scheduled []ScheduledChirp) {
+	sort.Slice(scheduled, func(i, j int) bool {
+		if !scheduled[i].PublishAt.Equal(scheduled[j].PublishAt) {
+			return scheduled[i].PublishAt.Before(scheduled[j].PublishAt)
+		}
+		return scheduled[i].Id < scheduled[j].Id
+	})
+}
@@ -0,0 +1,277 @@
+package database
+
+import (
+	"maps"
+	"math"
+	"os"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SearchIndex is a positional inverted index over chirp bodies, it maps
+// each term to the chirps containing it and the word positions it occurs at
+type SearchIndex map[string]map[int][]int
+
+// clone copies the index deep enough for indexChirp and unindexChirp to
+// change the copy, the position lists are never changed in place
+func (index SearchIndex) clone() SearchIndex {
+	copied := make(SearchIndex, len(index))
+	for term, postings := range index {
+		copied[term] = maps.Clone(postings)
+	}
+	return copied
+}
+
+// the search index of each database file is kept in memory instead of in the
+// file, it is built from the chirps on first use and replaced after every
+// write. A cached index is never changed, so readers can use it unlocked.
+var (
+	searchIndexesMux = &sync.Mutex{}
+	searchIndexes    = map[string]cachedIndex{}
+)
+
+// cachedIndex is the search index for a database file as of its last write,
+// it no longer applies once the file has changed in any other way
+type cachedIndex struct {
+	index   SearchIndex
+	modTime time.Time
+	size    int64
+}
+
+func cachedSearchIndex(path string, info os.FileInfo) (SearchIndex, bool) {
+	searchIndexesMux.Lock()
+	defer searchIndexesMux.Unlock()
+	cached, ok := searchIndexes[path]
+	if !ok || !cached.modTime.Equal(info.ModTime()) || cached.size != info.Size() {
+		return nil, false
+	}
+	return cached.index, true
+}
+
+func cacheSearchIndex(path string, info os.FileInfo, index SearchIndex) {
+	searchIndexesMux.Lock()
+	defer searchIndexesMux.Unlock()
+	searchIndexes[path] = cachedIndex{index: index, modTime: info.ModTime(), size: info.Size()}
+}
+
+// Token is one indexed term and the position of the word it came from,
+// a hashtag yields both the plain word and the word with its #
+type Token struct {
+	Term     string
+	Position int
+}
+
+// SearchQuery is a parsed search, a chirp has to contain every term and
+// every phrase to match
+type SearchQuery struct {
+	Terms    []string
+	Phrases  [][]string
+	AuthorId int
+	Since    *time.Time
+	Until    *time.Time
+}
+
+// SearchHit is a matching chirp and its relevance score
+type SearchHit struct {
+	Chirp Chirp
+	Score float64
+}
+
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) || r == '_'
+}
+
+// Tokenize splits text into case folded words for the index
+func Tokenize(text string) []Token {
+	text = norm.NFC.String(text)
+	tokens := []Token{}
+	position := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !isTermRune(r) {
+			i += size
+			continue
+		}
+		end := i
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			if !isTermRune(r) {
+				break
+			}
+			end += size
+		}
+		term := norm.NFC.String(cases.Fold().String(text[i:end]))
+		tokens = append(tokens, Token{Term: term, Position: position})
+		if i > 0 && text[i-1] == '#' && !followsWord(text[:i-1]) {
+			tokens = append(tokens, Token{Term: "#" + term, Position: position})
+		}
+		position++
+		i = end
+	}
+	return tokens
+}
+
+func followsWord(before string) bool {
+	r, _ := utf8.DecodeLastRuneInString(before)
+	return before != "" && isTermRune(r)
+}
+
+// QueryTerms tokenizes search input into one term per word, a word
+// written as a hashtag only matches the hashtag
+func QueryTerms(text string) []string {
+	terms := []string{}
+	for _, token := range Tokenize(text) {
+		if len(terms) > token.Position {
+			terms[token.Position] = token.Term
+			continue
+		}
+		terms = append(terms, token.Term)
+	}
+	return terms
+}
+
+// SearchChirps returns every chirp matching the query with a tf-idf score,
+// rechirps have no text of their own and are never returned
+func (db *DB) SearchChirps(query SearchQuery) ([]SearchHit, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	index := dbStruct.SearchIndex
+
+	required := append([]string{}, query.Terms...)
+	for _, phrase := range query.Phrases {
+		required = append(required, phrase...)
+	}
+	hits := []SearchHit{}
+	if len(required) == 0 {
+		// a query of only filters matches everything they let through
+		for _, chirp := range dbStruct.Chirps {
+			if chirp.RechirpOf == 0 && matchesFilters(chirp, query) {
+				hits = append(hits, SearchHit{Chirp: chirp})
+			}
+		}
+		return hits, nil
+	}
+
+	// start from the rarest term to keep the candidate set small
+	rarest := required[0]
+	for _, term := range required {
+		if len(index[term]) < len(index[rarest]) {
+			rarest = term
+		}
+	}
+
+	for chirpId := range index[rarest] {
+		chirp, ok := dbStruct.Chirps[chirpId]
+		if !ok || !matchesFilters(chirp, query) || !containsAll(index, chirpId, required) {
+			continue
+		}
+		matched := true
+		for _, phrase := range query.Phrases {
+			if !containsPhrase(index, chirpId, phrase) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		score := 0.0
+		for _, term := range required {
+			idf := math.Log(1 + float64(len(dbStruct.Chirps))/float64(len(index[term])))
+			score += float64(len(index[term][chirpId])) * idf
+		}
+		hits = append(hits, SearchHit{Chirp: chirp, Score: score})
+	}
+	return hits, nil
+}
+
+func matchesFilters(chirp Chirp, query SearchQuery) bool {
+	if query.AuthorId != 0 && chirp.Author != query.AuthorId {
+		return false
+	}
+	if query.Since != nil && chirp.CreatedAt.Before(*query.Since) {
+		return false
+	}
+	if query.Until != nil && !chirp.CreatedAt.Before(*query.Until) {
+		return false
+	}
+	return true
+}
+
+func containsAll(index SearchIndex, chirpId int, terms []string) bool {
+	for _, term := range terms {
+		if _, ok := index[term][chirpId]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// containsPhrase checks the terms of a phrase occur at consecutive positions
+func containsPhrase(index SearchIndex, chirpId int, phrase []string) bool {
+	if len(phrase) == 0 {
+		return true
+	}
+	for _, start := range index[phrase[0]][chirpId] {
+		found := true
+		for offset, term := range phrase[1:] {
+			if !containsInt(index[term][chirpId], start+offset+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// indexChirp adds a chirp's body to the search index
+func indexChirp(structure *DBStructure, chirp Chirp) {
+	for _, token := range Tokenize(chirp.Body) {
+		postings, ok := structure.SearchIndex[token.Term]
+		if !ok {
+			postings = map[int][]int{}
+			structure.SearchIndex[token.Term] = postings
+		}
+		postings[chirp.Id] = append(postings[chirp.Id], token.Position)
+	}
+}
+
+// unindexChirp removes a chirp's body from the search index
+func unindexChirp(structure *DBStructure, chirp Chirp) {
+	for _, token := range Tokenize(chirp.Body) {
+		postings := structure.SearchIndex[token.Term]
+		delete(postings, chirp.Id)
+		if len(postings) == 0 {
+			delete(structure.SearchIndex, token.Term)
+		}
+	}
+}
+
+// buildSearchIndex indexes every chirp, it is used when a database file is
+// first read and whenever it was changed by something other than this process
+func buildSearchIndex(structure *DBStructure) {
+	structure.SearchIndex = SearchIndex{}
+	for _, chirp := range structure.Chirps {
+		indexChirp(structure, chirp)
+	}
+}
@@ -0,0 +1,47 @@
+package database
+
+// GetChirpsByTag returns up to limit chirps tagged with tag, newest first,
+// starting after the chirp id before (0 starts at the top). The second value
+// is the cursor for the next page, 0 when there are no more chirps.
+func (db *DB) GetChirpsByTag(tag string, before int, limit int) ([]Chirp, int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := []int{}
+	for id, chirp := range dbStruct.Chirps {
+		if (before == 0 || id < before) && hasTag(chirp, tag) {
+			ids = append(ids, id)
+		}
+	}
+	return pageChirps(&dbStruct, ids, limit)
+}
+
+// GetTagCount returns how many chirps currently carry a tag
+func (db *DB) GetTagCount(tag string) (int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	return dbStruct.TagCounts[tag], nil
+}
+
+func hasTag(chirp Chirp, tag string) bool {
+	for _, chirpTag := range chirp.Tags {
+		if chirpTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// countTags adds delta to the count of every tag on a chirp
+func countTags(structure *DBStructure, tags []string, delta int) {
+	for _, tag := range tags {
+		structure.TagCounts[tag] += delta
+		if structure.TagCounts[tag] <= 0 {
+			delete(structure.TagCounts, tag)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// AccessToken is a long-lived personal access token, only the
+// sha256 hash of the secret is ever stored
+type AccessToken struct {
+	Id        string     `json:"id"`
+	UserId    int        `json:"user_id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAccessToken stores a new personal access token for a user that
+// hasn't been deleted
+func (db *DB) CreateAccessToken(token AccessToken) (AccessToken, error) {
+	err := db.transact(func(structure *DBStructure) error {
+		if usr, ok := structure.Users[token.UserId]; !ok || usr.Deleted {
+			return fmt.Errorf("%w: %d", ErrUserNotFound, token.UserId)
+		}
+		if _, ok := structure.AccessTokens[token.Id]; ok {
+			return fmt.Errorf("access token %s already exists", token.Id)
+		}
+		structure.AccessTokens[token.Id] = token
+		return nil
+	})
+	if err != nil {
+		return AccessToken{}, err
+	}
+	return token, nil
+}
+
+// GetAccessTokens returns the personal access tokens owned by a user
+func (db *DB) GetAccessTokens(usrId int) ([]AccessToken, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	tokens := []AccessToken{}
+	for _, token := range dbStruct.AccessTokens {
+		if token.UserId == usrId {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// CheckAccessToken finds an unexpired personal access token by the hash of its secret
+func (db *DB) CheckAccessToken(hash string) (AccessToken, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return AccessToken{}, err
+	}
+	for _, token := range dbStruct.AccessTokens {
+		if token.Hash != hash {
+			continue
+		}
+		if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+			return AccessToken{}, fmt.Errorf("access token expired")
+		}
+		return token, nil
+	}
+	return AccessToken{}, fmt.Errorf("access token not found")
+}
+
+// DeleteAccessToken revokes one of a user's personal access tokens
+func (db *DB) DeleteAccessToken(usrId int, tokenId string) error {
+	return db.transact(func(structure *DBStructure) error {
+		token, ok := structure.AccessTokens[tokenId]
+		if !ok || token.UserId != usrId {
+			return fmt.Errorf("access token not found")
+		}
+		delete(structure.AccessTokens, tokenId)
+		return nil
+	})
+}
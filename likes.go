@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func (cfg *apiConfig) likeHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.setLike(w, r, true)
+}
+
+func (cfg *apiConfig) unlikeHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.setLike(w, r, false)
+}
+
+func (cfg *apiConfig) setLike(w http.ResponseWriter, r *http.Request, liked bool) {
+	info, err := cfg.authorize(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	chirpId, err := strconv.Atoi(r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp id")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	if liked {
+		err = dbHandle.LikeChirp(info.UserId, chirpId)
+	} else {
+		err = dbHandle.UnlikeChirp(info.UserId, chirpId)
+	}
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	respondWithJSON(w, 204, "")
+}
+
+// userLikesHandle lists the chirps a user has liked, most recently liked first
+func (cfg *apiConfig) userLikesHandle(w http.ResponseWriter, r *http.Request) {
+	usrId, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	if _, err := dbHandle.GetUser(usrId); err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	likes, err := dbHandle.GetLikes()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	userLikes := []database.Like{}
+	for _, like := range likes {
+		if like.UserId == usrId {
+			userLikes = append(userLikes, like)
+		}
+	}
+	sort.SliceStable(userLikes, func(i, j int) bool {
+		return userLikes[i].CreatedAt.After(userLikes[j].CreatedAt)
+	})
+
+	allChirps, err := dbHandle.GetChirps()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	chirpsById := map[int]database.Chirp{}
+	for _, chirp := range allChirps {
+		chirpsById[chirp.Id] = chirp
+	}
+	chirps := []database.Chirp{}
+	for _, like := range userLikes {
+		if chirp, ok := chirpsById[like.ChirpId]; ok {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	resp, err := buildChirpResponses(dbHandle, chirps, cfg.chirpViewFor(r))
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 200, resp)
+}
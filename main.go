@@ -4,41 +4,172 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
 )
 
 type apiConfig struct {
 	fileserverHits int
 	jwtSecret      string
 	polkaKey       string
+	hasher         *passwordHasher
+	deletionPolicy database.DeletionPolicy
+	adminEmail     string
+	wordFilter     *wordListFilter
+	contentFilters filterChain
+	chirpLimits    chirpLimits
+	editWindow     time.Duration
+	trends         *trendStore
+	blobs          BlobStore
+	mediaMaxBytes  int64
+	// mediaMux serializes uploads with the deletion of orphaned blobs
+	mediaMux *sync.Mutex
 }
 
 func main() {
 
 	godotenv.Load()
 
+	hashParams := defaultArgon2Params()
+	hashParams.Memory = uint32(getEnvInt("ARGON2_MEMORY_KIB", int(hashParams.Memory)))
+	hashParams.Iterations = uint32(getEnvInt("ARGON2_ITERATIONS", int(hashParams.Iterations)))
+	hashParams.Parallelism = uint8(getEnvInt("ARGON2_PARALLELISM", int(hashParams.Parallelism)))
+
+	deletionPolicy := database.DeleteChirps
+	if os.Getenv("ACCOUNT_DELETION_POLICY") == "anonymize" {
+		deletionPolicy = database.AnonymizeChirps
+	}
+
+	filterPath := os.Getenv("FILTER_CONFIG")
+	if filterPath == "" {
+		filterPath = "./filters.json"
+	}
+	wordFilter, err := newWordListFilter(filterPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "./media"
+	}
+	blobs, err := newLocalBlobStore(mediaDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	apiCfg := apiConfig{
 		fileserverHits: 0,
 		jwtSecret:      os.Getenv("JWT_SECRET"),
 		polkaKey:       os.Getenv("POLKA_KEY"),
+		hasher:         newPasswordHasher(hashParams),
+		deletionPolicy: deletionPolicy,
+		adminEmail:     os.Getenv("ADMIN_EMAIL"),
+		wordFilter:     wordFilter,
+		contentFilters: filterChain{wordFilter},
+		chirpLimits: chirpLimits{
+			Default: getEnvInt("CHIRP_MAX_LENGTH", 140),
+			Red:     getEnvInt("CHIRP_MAX_LENGTH_RED", 280),
+		},
+		editWindow:    time.Duration(getEnvInt("CHIRP_EDIT_WINDOW_SECONDS", 15*60)) * time.Second,
+		trends:        newTrendStore(),
+		blobs:         blobs,
+		mediaMaxBytes: int64(getEnvInt("MEDIA_MAX_BYTES", 5<<20)),
+		mediaMux:      &sync.Mutex{},
+	}
+
+	go apiCfg.runTrendsWorker(time.Duration(getEnvInt("TRENDS_INTERVAL_SECONDS", 60)) * time.Second)
+	go apiCfg.runMediaSweeper(mediaSweepInterval)
+	go apiCfg.runScheduler(time.Duration(getEnvInt("SCHEDULER_INTERVAL_SECONDS", 5)) * time.Second)
+
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
+	go func() {
+		for range reloadSignal {
+			err := wordFilter.reload()
+			if err != nil {
+				log.Printf("Unable to reload content filters: %s", err)
+				continue
+			}
+			log.Printf("Reloaded content filters from %s", filterPath)
+		}
+	}()
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		log.Fatal(err)
 	}
+	apiCfg.bootstrapAdmin(dbHandle)
 
 	httpMux := http.NewServeMux()
 	httpMux.Handle("/app/*", apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(".")))))
 	httpMux.HandleFunc("GET /api/healthz", readinessHandle)
-	httpMux.HandleFunc("GET /admin/metrics", apiCfg.metricsHandle)
-	httpMux.HandleFunc("GET /api/reset", apiCfg.resetHandle)
+	httpMux.HandleFunc("GET /admin/metrics", apiCfg.requireRole(database.RoleAdmin, apiCfg.metricsHandle))
+	httpMux.HandleFunc("GET /api/reset", apiCfg.requireRole(database.RoleAdmin, apiCfg.resetHandle))
+	httpMux.HandleFunc("PUT /admin/users/{userId}/role", apiCfg.requireRole(database.RoleAdmin, apiCfg.setRoleHandle))
+	httpMux.HandleFunc("DELETE /admin/users/{userId}/role", apiCfg.requireRole(database.RoleAdmin, apiCfg.revokeRoleHandle))
+	httpMux.HandleFunc("GET /admin/roles/audit", apiCfg.requireRole(database.RoleAdmin, roleAuditHandle))
+	httpMux.HandleFunc("GET /admin/audit", apiCfg.requireRole(database.RoleAdmin, auditHandle))
+	httpMux.HandleFunc("POST /admin/filters/reload", apiCfg.requireRole(database.RoleAdmin, apiCfg.reloadFiltersHandle))
+	httpMux.HandleFunc("GET /admin/moderation/flagged", apiCfg.requireRole(database.RoleModerator, flaggedChirpsHandle))
 	httpMux.HandleFunc("POST /api/chirps", apiCfg.createHandle)
-	httpMux.HandleFunc("GET /api/chirps/{chirpId}", getHandle)
+	httpMux.HandleFunc("GET /api/chirps/{chirpId}", apiCfg.getHandle)
 	httpMux.HandleFunc("DELETE /api/chirps/{chirpId}", apiCfg.deleteHandle)
-	httpMux.HandleFunc("GET /api/chirps", getHandle)
+	httpMux.HandleFunc("PUT /api/chirps/{chirpId}", apiCfg.editChirpHandle)
+	httpMux.HandleFunc("GET /api/chirps/{chirpId}/history", apiCfg.chirpHistoryHandle)
+	httpMux.HandleFunc("GET /api/chirps/{chirpId}/replies", apiCfg.repliesHandle)
+	httpMux.HandleFunc("GET /api/chirps/{chirpId}/thread", apiCfg.threadHandle)
+	httpMux.HandleFunc("POST /api/chirps/{chirpId}/like", apiCfg.likeHandle)
+	httpMux.HandleFunc("DELETE /api/chirps/{chirpId}/like", apiCfg.unlikeHandle)
+	httpMux.HandleFunc("GET /api/chirps", apiCfg.getHandle)
+	httpMux.HandleFunc("GET /api/scheduled_chirps", apiCfg.listScheduledHandle)
+	httpMux.HandleFunc("GET /api/scheduled_chirps/{scheduledId}", apiCfg.getScheduledHandle)
+	httpMux.HandleFunc("PUT /api/scheduled_chirps/{scheduledId}", apiCfg.editScheduledHandle)
+	httpMux.HandleFunc("DELETE /api/scheduled_chirps/{scheduledId}", apiCfg.cancelScheduledHandle)
 	httpMux.HandleFunc("POST /api/users", apiCfg.createUserHandle)
 	httpMux.HandleFunc("POST /api/login", apiCfg.authenticateHandle)
 	httpMux.HandleFunc("PUT /api/users", apiCfg.updateUsrHandle)
+	httpMux.HandleFunc("PATCH /api/users", apiCfg.patchUsrHandle)
+	httpMux.HandleFunc("DELETE /api/users", apiCfg.deleteUserHandle)
+	httpMux.HandleFunc("GET /api/users/me/export", apiCfg.exportHandle)
+	httpMux.HandleFunc("GET /api/users/{handle}", getProfileHandle)
+	httpMux.HandleFunc("GET /api/users/{userId}/likes", apiCfg.userLikesHandle)
+	httpMux.HandleFunc("POST /api/users/{userId}/follow", apiCfg.followHandle)
+	httpMux.HandleFunc("DELETE /api/users/{userId}/follow", apiCfg.unfollowHandle)
+	httpMux.HandleFunc("GET /api/users/{userId}/followers", followersHandle)
+	httpMux.HandleFunc("GET /api/users/{userId}/following", followingHandle)
+	httpMux.HandleFunc("GET /api/timeline", apiCfg.timelineHandle)
+	httpMux.HandleFunc("GET /api/tags/{tag}", apiCfg.tagHandle)
+	httpMux.HandleFunc("GET /api/search", apiCfg.searchHandle)
+	httpMux.HandleFunc("GET /api/trends", apiCfg.trendsHandle)
+	httpMux.HandleFunc("POST /api/media", apiCfg.uploadMediaHandle)
+	httpMux.HandleFunc("GET /media/{key}", apiCfg.serveMediaHandle)
+	httpMux.HandleFunc("GET /api/notifications", apiCfg.notificationsHandle)
+	httpMux.HandleFunc("GET /api/notifications/unread_count", apiCfg.unreadCountHandle)
+	httpMux.HandleFunc("POST /api/notifications/read", apiCfg.markAllReadHandle)
+	httpMux.HandleFunc("POST /api/notifications/{notificationId}/read", apiCfg.markReadHandle)
+	httpMux.HandleFunc("POST /api/users/{userId}/block", apiCfg.blockHandle)
+	httpMux.HandleFunc("DELETE /api/users/{userId}/block", apiCfg.unblockHandle)
+	httpMux.HandleFunc("POST /api/users/{userId}/mute", apiCfg.muteHandle)
+	httpMux.HandleFunc("DELETE /api/users/{userId}/mute", apiCfg.unmuteHandle)
+	httpMux.HandleFunc("GET /api/users/me/blocks", apiCfg.listBlocksHandle)
+	httpMux.HandleFunc("GET /api/users/me/mutes", apiCfg.listMutesHandle)
 	httpMux.HandleFunc("POST /api/refresh", apiCfg.refreshHandle)
 	httpMux.HandleFunc("POST /api/revoke", apiCfg.revokeTokenHandle)
 	httpMux.HandleFunc("POST /api/polka/webhooks", apiCfg.redWebhook)
+	httpMux.HandleFunc("POST /api/tokens", apiCfg.createAccessTokenHandle)
+	httpMux.HandleFunc("GET /api/tokens", apiCfg.listAccessTokensHandle)
+	httpMux.HandleFunc("DELETE /api/tokens/{tokenId}", apiCfg.revokeAccessTokenHandle)
+	httpMux.HandleFunc("POST /api/oauth/clients", apiCfg.registerClientHandle)
+	httpMux.HandleFunc("GET /oauth/authorize", apiCfg.authorizePageHandle)
+	httpMux.HandleFunc("POST /oauth/authorize", apiCfg.authorizeDecisionHandle)
+	httpMux.HandleFunc("POST /oauth/token", apiCfg.oauthTokenHandle)
 
 	httpServer := &http.Server{
 		Addr:    ":8080",
@@ -48,3 +179,13 @@ func main() {
 	log.Println("Starting server on port: 8080")
 	log.Fatal(httpServer.ListenAndServe())
 }
+
+// getEnvInt reads an integer from the environment, falling back to def
+// when the variable is unset or not a number
+func getEnvInt(key string, def int) int {
+	val, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || val <= 0 {
+		return def
+	}
+	return val
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	maxAltTextLength = 1000
+	// unattachedMediaMaxAge is how long an upload may wait to be attached
+	unattachedMediaMaxAge = 24 * time.Hour
+	mediaSweepInterval    = 10 * time.Minute
+)
+
+// allowedMediaTypes are the content types we accept, as sniffed from the
+// upload itself
+var allowedMediaTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// mediaKeyRegex matches the content hash names variants are stored under,
+// anything else in the blob store is never served
+var mediaKeyRegex = regexp.MustCompile(`^[0-9a-f]{32}\.(jpg|png)$`)
+
+type mediaVariantResponse struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+// mediaResponse describes the display variant at the top level, every
+// variant is listed under variants
+type mediaResponse struct {
+	Id string `json:"id"`
+	mediaVariantResponse
+	AltText  string                          `json:"alt_text"`
+	Variants map[string]mediaVariantResponse `json:"variants"`
+}
+
+func newMediaResponse(media database.Media) mediaResponse {
+	response := mediaResponse{
+		Id:       media.Id,
+		AltText:  media.AltText,
+		Variants: map[string]mediaVariantResponse{},
+	}
+	for name, variant := range media.Variants {
+		response.Variants[name] = mediaVariantResponse{
+			URL:         "/media/" + variant.Key,
+			ContentType: variant.ContentType,
+			Size:        variant.Size,
+			Width:       variant.Width,
+			Height:      variant.Height,
+		}
+	}
+	response.mediaVariantResponse = response.Variants["display"]
+	return response
+}
+
+// uploadMediaHandle accepts a multipart upload with the image in the file
+// field and an optional alt_text field
+func (cfg *apiConfig) uploadMediaHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+
+	// leave room for the multipart framing and the other fields
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.mediaMaxBytes+64*1024)
+	err = r.ParseMultipartForm(1 << 20)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(w, 413, fmt.Sprintf("Uploads are limited to %d bytes", cfg.mediaMaxBytes))
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, "Expected a multipart form")
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		respondWithError(w, 400, "Missing file")
+		return
+	}
+	defer file.Close()
+	if header.Size > cfg.mediaMaxBytes {
+		respondWithError(w, 413, fmt.Sprintf("Uploads are limited to %d bytes", cfg.mediaMaxBytes))
+		return
+	}
+	altText := strings.TrimSpace(r.FormValue("alt_text"))
+	if utf8.RuneCountInString(altText) > maxAltTextLength {
+		respondWithError(w, 400, fmt.Sprintf("Alt text must be at most %d characters", maxAltTextLength))
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, 400, "Unable to read upload")
+		return
+	}
+	// the client's Content-Type header is ignored, only the bytes count
+	contentType := http.DetectContentType(data)
+	if !allowedMediaTypes[contentType] {
+		respondWithError(w, 415, "Only PNG, JPEG, GIF and WebP images are supported")
+		return
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || "image/"+format != contentType {
+		respondWithError(w, 400, "Unable to decode image")
+		return
+	}
+	if config.Width*config.Height > maxMediaPixels {
+		respondWithError(w, 400, fmt.Sprintf("Images are limited to %d pixels", maxMediaPixels))
+		return
+	}
+	encoded, err := processImage(data)
+	if err != nil {
+		respondWithError(w, 400, "Unable to decode image")
+		return
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		respondWithError(w, 500, "Unable to generate media id")
+		return
+	}
+	variants := map[string]database.MediaVariant{}
+	// stored holds the blobs this upload created, blobs that already existed
+	// belong to other media as well and must be left alone
+	stored := []string{}
+	removeStored := func() {
+		for _, key := range stored {
+			cfg.blobs.Delete(key)
+		}
+	}
+	// the sweeper must not delete a blob this upload is about to reuse
+	cfg.mediaMux.Lock()
+	defer cfg.mediaMux.Unlock()
+	for name, variant := range encoded {
+		created, err := putBlobIfMissing(cfg.blobs, variant.Key, variant.Data)
+		if err != nil {
+			removeStored()
+			respondWithError(w, 500, "Unable to store upload")
+			return
+		}
+		if created {
+			stored = append(stored, variant.Key)
+		}
+		variants[name] = database.MediaVariant{
+			Key:         variant.Key,
+			ContentType: variant.ContentType,
+			Size:        int64(len(variant.Data)),
+			Width:       variant.Width,
+			Height:      variant.Height,
+		}
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		removeStored()
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	media, err := dbHandle.CreateMedia(database.Media{
+		Id:       id,
+		OwnerId:  info.UserId,
+		Variants: variants,
+		AltText:  altText,
+	})
+	if err != nil {
+		removeStored()
+		respondWithError(w, 500, "Unable to write to database")
+		return
+	}
+	respondWithJSON(w, 201, newMediaResponse(media))
+}
+
+// putBlobIfMissing stores data under key unless the blob already exists and
+// reports whether it wrote it
+func putBlobIfMissing(blobs BlobStore, key string, data []byte) (bool, error) {
+	existing, err := blobs.Get(key)
+	if err == nil {
+		existing.Close()
+		return false, nil
+	}
+	if !errors.Is(err, errBlobNotFound) {
+		return false, err
+	}
+	return true, blobs.Put(key, bytes.NewReader(data))
+}
+
+// serveMediaHandle serves a variant by its content hash name, the bytes
+// behind a name never change so clients may cache them for good
+func (cfg *apiConfig) serveMediaHandle(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !mediaKeyRegex.MatchString(key) {
+		respondWithError(w, 404, "Media not found")
+		return
+	}
+	blob, err := cfg.blobs.Get(key)
+	if err != nil {
+		respondWithError(w, 404, "Media not found")
+		return
+	}
+	defer blob.Close()
+
+	contentType := "image/jpeg"
+	if strings.HasSuffix(key, ".png") {
+		contentType = "image/png"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(key, filepath.Ext(key))+`"`)
+	http.ServeContent(w, r, "", time.Time{}, blob)
+}
+
+// runMediaSweeper expires uploads nobody attached and removes the blobs of
+// deleted media from the blob store
+func (cfg *apiConfig) runMediaSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		dbHandle, err := database.NewDB("./database.json")
+		if err != nil {
+			log.Printf("Unable to sweep media: %s", err)
+			continue
+		}
+		err = dbHandle.ExpireUnattachedMedia(unattachedMediaMaxAge)
+		if err != nil {
+			log.Printf("Unable to expire media: %s", err)
+		}
+		err = cfg.deleteOrphanedBlobs(dbHandle)
+		if err != nil {
+			log.Printf("Unable to sweep media: %s", err)
+		}
+	}
+}
+
+func (cfg *apiConfig) deleteOrphanedBlobs(dbHandle *database.DB) error {
+	// uploads reuse existing blobs, so none may start while keys are
+	// between being taken and being deleted
+	cfg.mediaMux.Lock()
+	defer cfg.mediaMux.Unlock()
+	keys, err := dbHandle.TakeOrphanedBlobs()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := cfg.blobs.Delete(key); err != nil {
+			log.Printf("Unable to delete blob %s: %s", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+// resolveMentions turns the @handles in a chirp body into mentions of existing
+// users, handles that don't exist or belong to someone in a block with the
+// author are left as plain text
+func resolveMentions(dbHandle *database.DB, authorId int, body string) ([]database.Mention, error) {
+	return dbHandle.ResolveMentions(authorId, mentionCandidates(body))
+}
+
+// mentionCandidates extracts the @handles in a chirp body for the database
+// to look up
+func mentionCandidates(body string) []database.MentionCandidate {
+	candidates := []database.MentionCandidate{}
+	for _, candidate := range extractMentions(body) {
+		candidates = append(candidates, database.MentionCandidate{Handle: candidate.Handle, Start: candidate.Start, End: candidate.End})
+	}
+	return candidates
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+const (
+	actionMask   = "mask"
+	actionReject = "reject"
+	actionFlag   = "flag"
+
+	maskText = "****"
+)
+
+// FilterResult is what a ContentFilter did to a chirp body
+type FilterResult struct {
+	Body     string
+	Rejected bool
+	Matches  []database.FilterMatch
+}
+
+// ContentFilter inspects a chirp body before it is stored, it may rewrite
+// the body, reject it outright or flag it for a moderator to review
+type ContentFilter interface {
+	Name() string
+	Apply(body string) FilterResult
+}
+
+// filterChain runs each filter on the output of the previous one and
+// stops as soon as a filter rejects the chirp
+type filterChain []ContentFilter
+
+func (c filterChain) run(body string) FilterResult {
+	result := FilterResult{Body: body}
+	for _, filter := range c {
+		step := filter.Apply(result.Body)
+		result.Body = step.Body
+		result.Matches = append(result.Matches, step.Matches...)
+		if step.Rejected {
+			result.Rejected = true
+			return result
+		}
+	}
+	return result
+}
+
+// moderation converts the chain result into what is recorded on the chirp
+func (f FilterResult) moderation() *database.Moderation {
+	if len(f.Matches) == 0 {
+		return nil
+	}
+	moderation := &database.Moderation{Matches: f.Matches}
+	for _, match := range f.Matches {
+		if match.Action == actionFlag {
+			moderation.Flagged = true
+		}
+	}
+	return moderation
+}
+
+type wordRule struct {
+	Word   string `json:"word"`
+	Action string `json:"action"`
+}
+
+type wordListConfig struct {
+	Rules []wordRule `json:"rules"`
+}
+
+// wordListFilter matches whole words from a config file, it can be
+// reloaded while the server is running
+type wordListFilter struct {
+	path  string
+	mux   *sync.RWMutex
+	rules []compiledWordRule
+}
+
+type compiledWordRule struct {
+	wordRule
+	regex *regexp.Regexp
+}
+
+func defaultWordRules() []wordRule {
+	return []wordRule{
+		{Word: "kerfuffle", Action: actionMask},
+		{Word: "sharbert", Action: actionMask},
+		{Word: "fornax", Action: actionMask},
+	}
+}
+
+// newWordListFilter loads rules from path, falling back to the
+// default word list when the file doesn't exist
+func newWordListFilter(path string) (*wordListFilter, error) {
+	filter := &wordListFilter{
+		path: path,
+		mux:  &sync.RWMutex{},
+	}
+	err := filter.reload()
+	if err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func (f *wordListFilter) Name() string {
+	return "word_list"
+}
+
+func (f *wordListFilter) reload() error {
+	config := wordListConfig{Rules: defaultWordRules()}
+	dat, err := os.ReadFile(f.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		config = wordListConfig{}
+		err = json.Unmarshal(dat, &config)
+		if err != nil {
+			return fmt.Errorf("invalid filter config %s: %w", f.path, err)
+		}
+	}
+
+	rules := []compiledWordRule{}
+	for _, rule := range config.Rules {
+		rule.Word = strings.TrimSpace(rule.Word)
+		if rule.Word == "" {
+			continue
+		}
+		if rule.Action != actionMask && rule.Action != actionReject && rule.Action != actionFlag {
+			return fmt.Errorf("unknown action %s for word %s", rule.Action, rule.Word)
+		}
+		rules = append(rules, compiledWordRule{
+			wordRule: rule,
+			regex:    regexp.MustCompile("(?i)" + regexp.QuoteMeta(rule.Word)),
+		})
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.rules = rules
+	return nil
+}
+
+func (f *wordListFilter) Apply(body string) FilterResult {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	result := FilterResult{Body: body}
+	for _, rule := range f.rules {
+		matches := findWholeWords(result.Body, rule.regex)
+		if len(matches) == 0 {
+			continue
+		}
+		result.Matches = append(result.Matches, database.FilterMatch{Filter: f.Name(), Rule: rule.Word, Action: rule.Action})
+		switch rule.Action {
+		case actionReject:
+			result.Rejected = true
+			return result
+		case actionMask:
+			result.Body = replaceRanges(result.Body, matches, maskText)
+		}
+	}
+	return result
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r) || r == '_'
+}
+
+// findWholeWords returns the byte ranges where regex matches a whole word,
+// so that a rule for "ass" doesn't fire on "class" or "assistant"
+func findWholeWords(subject string, regex *regexp.Regexp) [][]int {
+	matches := [][]int{}
+	for _, loc := range regex.FindAllStringIndex(subject, -1) {
+		if loc[0] > 0 {
+			before, _ := utf8.DecodeLastRuneInString(subject[:loc[0]])
+			if isWordRune(before) {
+				continue
+			}
+		}
+		if loc[1] < len(subject) {
+			after, _ := utf8.DecodeRuneInString(subject[loc[1]:])
+			if isWordRune(after) {
+				continue
+			}
+		}
+		matches = append(matches, loc)
+	}
+	return matches
+}
+
+func replaceRanges(subject string, ranges [][]int, replace string) string {
+	builder := strings.Builder{}
+	last := 0
+	for _, loc := range ranges {
+		builder.WriteString(subject[last:loc[0]])
+		builder.WriteString(replace)
+		last = loc[1]
+	}
+	builder.WriteString(subject[last:])
+	return builder.String()
+}
+
+func (cfg *apiConfig) reloadFiltersHandle(w http.ResponseWriter, r *http.Request) {
+	err := cfg.wordFilter.reload()
+	if err != nil {
+		respondWithError(w, 500, fmt.Sprintf("Unable to reload filters: %v", err))
+		return
+	}
+	log.Printf("Reloaded content filters from %s", cfg.wordFilter.path)
+	respondWithJSON(w, 200, "")
+}
+
+func flaggedChirpsHandle(w http.ResponseWriter, r *http.Request) {
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+
+	chirps, err := dbHandle.GetChirps()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	flagged := []database.Chirp{}
+	for _, chirp := range chirps {
+		if chirp.Moderation != nil && chirp.Moderation.Flagged {
+			flagged = append(flagged, chirp)
+		}
+	}
+	sort.Slice(flagged, func(i, j int) bool {
+		return flagged[i].Id < flagged[j].Id
+	})
+	respondWithJSON(w, 200, flagged)
+}
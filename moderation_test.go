@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWordListFilter(t *testing.T) {
+	configPath := t.TempDir() + "/filters.json"
+	err := os.WriteFile(configPath, []byte(`{"rules": [
+		{"word": "fornax", "action": "mask"},
+		{"word": "straße", "action": "mask"},
+		{"word": "spam", "action": "reject"},
+		{"word": "sharbert", "action": "flag"}
+	]}`), 0644)
+	if err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+	filter, err := newWordListFilter(configPath)
+	if err != nil {
+		t.Fatalf("unable to load filter: %v", err)
+	}
+
+	cases := []struct {
+		input            string
+		expectedBody     string
+		expectedRejected bool
+		expectedFlagged  bool
+	}{
+		{input: "a Fornax, a fornax!", expectedBody: "a ****, a ****!"},
+		{input: "fornaxes stay", expectedBody: "fornaxes stay"},
+		{input: "die STRAßE und die Straße", expectedBody: "die **** und die ****"},
+		{input: "Straßenbahn", expectedBody: "Straßenbahn"},
+		{input: "buy spam now", expectedRejected: true},
+		{input: "spammy but fine", expectedBody: "spammy but fine"},
+		{input: "a sharbert appears", expectedBody: "a sharbert appears", expectedFlagged: true},
+	}
+
+	for _, c := range cases {
+		actual := filterChain{filter}.run(c.input)
+		if actual.Rejected != c.expectedRejected {
+			t.Errorf("run(%v) rejected == %v, expected %v", c.input, actual.Rejected, c.expectedRejected)
+			continue
+		}
+		if c.expectedRejected {
+			continue
+		}
+		if actual.Body != c.expectedBody {
+			t.Errorf("run(%v) == %v, expected %v", c.input, actual.Body, c.expectedBody)
+		}
+		flagged := actual.moderation() != nil && actual.moderation().Flagged
+		if flagged != c.expectedFlagged {
+			t.Errorf("run(%v) flagged == %v, expected %v", c.input, flagged, c.expectedFlagged)
+		}
+	}
+
+	err = os.WriteFile(configPath, []byte(`{"rules": [{"word": "fornax", "action": "reject"}]}`), 0644)
+	if err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+	err = filter.reload()
+	if err != nil {
+		t.Fatalf("unable to reload filter: %v", err)
+	}
+	if !filter.Apply("a fornax").Rejected {
+		t.Errorf("reloaded rule was not applied")
+	}
+}
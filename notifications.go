@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+// notificationResponse is an inbox entry with its actors resolved and a
+// human readable summary
+type notificationResponse struct {
+	database.NotificationGroup
+	Actors  []*authorSummary `json:"actors"`
+	Summary string           `json:"summary"`
+}
+
+type notificationPage struct {
+	Notifications []notificationResponse `json:"notifications"`
+	UnreadCount   int                    `json:"unread_count"`
+	NextCursor    int                    `json:"next_cursor,omitempty"`
+}
+
+func (cfg *apiConfig) notificationsHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	limit, before, ok := pageParams(r)
+	if !ok {
+		respondWithError(w, 400, "Invalid pagination parameters")
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	groups, next, err := dbHandle.GetNotificationGroups(info.UserId, unreadOnly, before, limit)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	unread, err := dbHandle.CountUnreadNotifications(info.UserId)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	resp := notificationPage{
+		Notifications: []notificationResponse{},
+		UnreadCount:   unread,
+		NextCursor:    next,
+	}
+	allUsers, err := dbHandle.GetUsers()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	usersById := map[int]database.User{}
+	for _, usr := range allUsers {
+		usersById[usr.Id] = usr
+	}
+	for _, group := range groups {
+		item := notificationResponse{NotificationGroup: group, Actors: []*authorSummary{}}
+		for _, actorId := range group.ActorIds {
+			if actor, ok := usersById[actorId]; ok {
+				item.Actors = append(item.Actors, newAuthorSummary(actor))
+			}
+		}
+		item.Summary = notificationSummary(item)
+		resp.Notifications = append(resp.Notifications, item)
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// notificationSummary describes an inbox entry, "5 people liked your chirp"
+func notificationSummary(item notificationResponse) string {
+	actor := "Someone"
+	if len(item.Actors) > 0 {
+		actor = displayName(item.Actors[0])
+	}
+	if item.Count > 1 {
+		actor = fmt.Sprintf("%d people", item.Count)
+	}
+
+	switch item.Type {
+	case database.NotificationMention:
+		return fmt.Sprintf("%s mentioned you", actor)
+	case database.NotificationReply:
+		return fmt.Sprintf("%s replied to your chirp", actor)
+	case database.NotificationLike:
+		return fmt.Sprintf("%s liked your chirp", actor)
+	case database.NotificationFollow:
+		return fmt.Sprintf("%s followed you", actor)
+	case database.NotificationRedUpgrade:
+		return "Your account was upgraded to Chirpy Red"
+	}
+	return ""
+}
+
+func displayName(author *authorSummary) string {
+	if author.DisplayName != "" {
+		return author.DisplayName
+	}
+	if author.Handle != "" {
+		return "@" + author.Handle
+	}
+	return "Someone"
+}
+
+func (cfg *apiConfig) unreadCountHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	unread, err := dbHandle.CountUnreadNotifications(info.UserId)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	type countResponse struct {
+		UnreadCount int `json:"unread_count"`
+	}
+	respondWithJSON(w, 200, countResponse{UnreadCount: unread})
+}
+
+// markReadHandle changes inbox state, so unlike reading notifications it
+// needs a token that may write to the profile
+func (cfg *apiConfig) markReadHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	notificationId, err := strconv.Atoi(r.PathValue("notificationId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid notification id")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	err = dbHandle.MarkNotificationRead(info.UserId, notificationId)
+	if err != nil {
+		respondWithError(w, 404, "Notification not found")
+		return
+	}
+	respondWithJSON(w, 204, "")
+}
+
+func (cfg *apiConfig) markAllReadHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	err = dbHandle.MarkAllNotificationsRead(info.UserId)
+	if err != nil {
+		respondWithError(w, 500, "Unable to write to database")
+		return
+	}
+	respondWithJSON(w, 204, "")
+}
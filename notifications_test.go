@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestNotificationHandles(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{jwtSecret: "secret"}
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	fan, _ := dbHandle.InsertUser(database.User{Email: "fan@boot.dev", Password: "pwd", Handle: "fan"})
+	dbHandle.FollowUser(fan.Id, usr.Id)
+
+	createPAT := func(id string, scopes ...string) string {
+		tokenStr := accessTokenPrefix + id
+		dbHandle.CreateAccessToken(database.AccessToken{Id: id, UserId: usr.Id, Hash: hashSecret(tokenStr), Scopes: scopes})
+		return "Bearer " + tokenStr
+	}
+	readOnly := createPAT("read", scopeChirpsRead)
+	profile := createPAT("profile", scopeProfileWrite)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/notifications", cfg.notificationsHandle)
+	mux.HandleFunc("POST /api/notifications/read", cfg.markAllReadHandle)
+	mux.HandleFunc("POST /api/notifications/{notificationId}/read", cfg.markReadHandle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/notifications", nil)
+	req.Header.Set("Authorization", readOnly)
+	mux.ServeHTTP(rec, req)
+	page := notificationPage{}
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if rec.Code != 200 || len(page.Notifications) != 1 {
+		t.Fatalf("notifications == %d %s, expected one notification", rec.Code, rec.Body.String())
+	}
+	item := page.Notifications[0]
+	if len(item.Actors) != 1 || item.Actors[0].Id != fan.Id || item.Summary != "@fan followed you" {
+		t.Errorf("notification == %+v, expected a follow by @fan", item)
+	}
+
+	cases := []struct {
+		name     string
+		path     string
+		auth     string
+		expected int
+	}{
+		{name: "mark read with read scope", path: fmt.Sprintf("/api/notifications/%d/read", item.Id), auth: readOnly, expected: 403},
+		{name: "mark all read with read scope", path: "/api/notifications/read", auth: readOnly, expected: 403},
+		{name: "mark read", path: fmt.Sprintf("/api/notifications/%d/read", item.Id), auth: profile, expected: 204},
+		{name: "mark all read", path: "/api/notifications/read", auth: profile, expected: 204},
+		{name: "unknown notification", path: "/api/notifications/99/read", auth: profile, expected: 404},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", c.path, nil)
+		req.Header.Set("Authorization", c.auth)
+		mux.ServeHTTP(rec, req)
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d", c.name, rec.Code, c.expected)
+		}
+	}
+}
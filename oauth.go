@@ -0,0 +1,430 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+const (
+	oauthCodeLifetime         = 10 * time.Minute
+	oauthAccessTokenLifetime  = time.Hour
+	oauthRefreshTokenLifetime = 60 * 24 * time.Hour
+)
+
+// authorizeRequest is the validated set of parameters of an authorization-code request
+type authorizeRequest struct {
+	Client        database.OAuthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<html>
+
+<body>
+    <h1>{{.Request.Client.Name}} wants to access your Chirpy account</h1>
+    <p>It is asking for:</p>
+    <ul>
+    {{range .Request.Scopes}}    <li>{{.}}</li>
+    {{end}}</ul>
+    {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+    <form method="POST" action="/oauth/authorize">
+        <input type="hidden" name="response_type" value="code">
+        <input type="hidden" name="client_id" value="{{.Request.Client.Id}}">
+        <input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+        <input type="hidden" name="scope" value="{{.Scope}}">
+        <input type="hidden" name="state" value="{{.Request.State}}">
+        <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+        <input type="hidden" name="code_challenge_method" value="S256">
+        <p><label>Email <input type="email" name="email"></label></p>
+        <p><label>Password <input type="password" name="password"></label></p>
+        <button type="submit" name="decision" value="approve">Allow</button>
+        <button type="submit" name="decision" value="deny">Deny</button>
+    </form>
+</body>
+
+</html>
+`))
+
+func randomHex(length int) (string, error) {
+	randomData := make([]byte, length)
+	_, err := rand.Read(randomData)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomData), nil
+}
+
+func validRedirectURI(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || parsed.Fragment != "" {
+		return false
+	}
+	if parsed.Scheme == "https" {
+		return true
+	}
+	return parsed.Scheme == "http" && (parsed.Hostname() == "localhost" || parsed.Hostname() == "127.0.0.1")
+}
+
+func (cfg *apiConfig) registerClientHandle(w http.ResponseWriter, r *http.Request) {
+	usrId, err := cfg.userIdFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		respondWithError(w, 400, "Client name is required")
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		respondWithError(w, 400, "At least one redirect uri is required")
+		return
+	}
+	for _, redirectURI := range params.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			respondWithError(w, 400, fmt.Sprintf("Invalid redirect uri %s", redirectURI))
+			return
+		}
+	}
+
+	clientId, err := randomHex(16)
+	if err != nil {
+		respondWithError(w, 500, "Unable to create client")
+		return
+	}
+	client := database.OAuthClient{
+		Id:           clientId,
+		OwnerId:      usrId,
+		Name:         name,
+		RedirectURIs: params.RedirectURIs,
+		CreatedAt:    time.Now().UTC(),
+	}
+	clientSecret := ""
+	if params.Confidential {
+		clientSecret, err = randomHex(32)
+		if err != nil {
+			respondWithError(w, 500, "Unable to create client")
+			return
+		}
+		client.SecretHash = hashSecret(clientSecret)
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	client, err = dbHandle.CreateOAuthClient(client)
+	if err != nil {
+		respondWithError(w, 500, "Unable to write to database")
+		return
+	}
+
+	recordAudit(r, usrId, auditOAuthClientCreate, "oauth_client:"+client.Id, outcomeSuccess)
+	type clientResponse struct {
+		ClientId     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret,omitempty"`
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+	}
+	respondWithJSON(w, 201, clientResponse{
+		ClientId:     client.Id,
+		ClientSecret: clientSecret,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+	})
+}
+
+// parseAuthorizeRequest validates an authorization request, if the returned
+// redirect flag is false the error must not be sent to the redirect uri
+func parseAuthorizeRequest(values url.Values) (authorizeRequest, bool, error) {
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		return authorizeRequest{}, false, err
+	}
+	client, err := dbHandle.GetOAuthClient(values.Get("client_id"))
+	if err != nil {
+		return authorizeRequest{}, false, fmt.Errorf("unknown client")
+	}
+	redirectURI := values.Get("redirect_uri")
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return authorizeRequest{}, false, fmt.Errorf("redirect uri is not registered for this client")
+	}
+
+	req := authorizeRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         values.Get("state"),
+		CodeChallenge: values.Get("code_challenge"),
+	}
+	if values.Get("response_type") != "code" {
+		return req, true, fmt.Errorf("unsupported_response_type")
+	}
+	if req.CodeChallenge == "" || values.Get("code_challenge_method") != "S256" {
+		return req, true, fmt.Errorf("invalid_request")
+	}
+	for _, scope := range strings.Fields(values.Get("scope")) {
+		if !slices.Contains(grantableScopes, scope) {
+			return req, true, fmt.Errorf("invalid_scope")
+		}
+		if !slices.Contains(req.Scopes, scope) {
+			req.Scopes = append(req.Scopes, scope)
+		}
+	}
+	if len(req.Scopes) == 0 {
+		return req, true, fmt.Errorf("invalid_scope")
+	}
+	return req, false, nil
+}
+
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	target, _ := url.Parse(redirectURI)
+	query := target.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func renderConsent(w http.ResponseWriter, code int, req authorizeRequest, errMsg string) {
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	consentTemplate.Execute(w, struct {
+		Request authorizeRequest
+		Scope   string
+		Error   string
+	}{
+		Request: req,
+		Scope:   strings.Join(req.Scopes, " "),
+		Error:   errMsg,
+	})
+}
+
+func (cfg *apiConfig) authorizePageHandle(w http.ResponseWriter, r *http.Request) {
+	req, redirect, err := parseAuthorizeRequest(r.URL.Query())
+	if err != nil {
+		if redirect {
+			redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {err.Error()}, "state": {req.State}})
+			return
+		}
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	renderConsent(w, 200, req, "")
+}
+
+func (cfg *apiConfig) authorizeDecisionHandle(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithError(w, 400, "Invalid form")
+		return
+	}
+	req, redirect, err := parseAuthorizeRequest(r.PostForm)
+	if err != nil {
+		if redirect {
+			redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {err.Error()}, "state": {req.State}})
+			return
+		}
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	if r.PostForm.Get("decision") != "approve" {
+		recordAudit(r, 0, auditOAuthAuthorize, "oauth_client:"+req.Client.Id, outcomeDenied)
+		redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {"access_denied"}, "state": {req.State}})
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+
+	userEmail := strings.TrimSpace(r.PostForm.Get("email"))
+	userPassword := strings.TrimSpace(r.PostForm.Get("password"))
+	usr, _, err := cfg.findLoginUser(dbHandle, userEmail, userPassword)
+	if errors.Is(err, errInvalidLogin) {
+		recordAudit(r, 0, auditOAuthAuthorize, "oauth_client:"+req.Client.Id, outcomeFailure)
+		renderConsent(w, 401, req, "Incorrect email or password")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Unable to verify password")
+		return
+	}
+
+	code, err := randomHex(32)
+	if err != nil {
+		respondWithError(w, 500, "Unable to create authorization code")
+		return
+	}
+	err = dbHandle.CreateAuthorizationCode(database.AuthorizationCode{
+		Hash:                hashSecret(code),
+		ClientId:            req.Client.Id,
+		UserId:              usr.Id,
+		RedirectURI:         req.RedirectURI,
+		Scopes:              req.Scopes,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: "S256",
+		Expiration:          time.Now().Add(oauthCodeLifetime),
+	})
+	if err != nil {
+		respondWithError(w, 500, "Unable to write to database")
+		return
+	}
+
+	recordAudit(r, usr.Id, auditOAuthAuthorize, "oauth_client:"+req.Client.Id, outcomeSuccess)
+	redirectWithParams(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode string, description string) {
+	type oauthError struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, oauthError{Error: errCode, Description: description})
+}
+
+// authenticateClient checks the client credentials sent to the token endpoint,
+// public clients only identify themselves and rely on PKCE instead
+func authenticateClient(dbHandle *database.DB, r *http.Request) (database.OAuthClient, error) {
+	clientId, clientSecret, hasBasic := r.BasicAuth()
+	if !hasBasic {
+		clientId = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := dbHandle.GetOAuthClient(clientId)
+	if err != nil {
+		return database.OAuthClient{}, err
+	}
+	if client.SecretHash != "" && subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashSecret(clientSecret))) != 1 {
+		return database.OAuthClient{}, fmt.Errorf("invalid client secret")
+	}
+	return client, nil
+}
+
+func verifyPKCE(verifier string, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return verifier != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func (cfg *apiConfig) oauthTokenHandle(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", "Invalid form")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "Unable to connect to database")
+		return
+	}
+
+	client, err := authenticateClient(dbHandle, r)
+	if err != nil {
+		recordAudit(r, 0, auditOAuthToken, "oauth_client:"+r.PostForm.Get("client_id"), outcomeDenied)
+		respondWithOAuthError(w, 401, "invalid_client", err.Error())
+		return
+	}
+
+	usrId := 0
+	scopes := []string{}
+	refreshToken := ""
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, err := dbHandle.ConsumeAuthorizationCode(hashSecret(r.PostForm.Get("code")))
+		if err != nil {
+			respondWithOAuthError(w, 400, "invalid_grant", err.Error())
+			return
+		}
+		if code.ClientId != client.Id || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, 400, "invalid_grant", "Authorization code was issued to another client or redirect uri")
+			return
+		}
+		if !verifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, 400, "invalid_grant", "Code verifier does not match")
+			return
+		}
+
+		newRefreshToken, err := dbHandle.CreateClientRefreshToken(time.Now().Add(oauthRefreshTokenLifetime), code.UserId, client.Id, code.Scopes)
+		if err != nil {
+			respondWithOAuthError(w, 500, "server_error", "Unable to create refresh token")
+			return
+		}
+		usrId = code.UserId
+		scopes = code.Scopes
+		refreshToken = newRefreshToken.Token
+	case "refresh_token":
+		validToken, err := dbHandle.CheckRefreshToken(r.PostForm.Get("refresh_token"))
+		if err != nil || validToken.ClientId != client.Id {
+			respondWithOAuthError(w, 400, "invalid_grant", "Invalid refresh token")
+			return
+		}
+		usrId = validToken.Id
+		scopes = validToken.Scopes
+	default:
+		respondWithOAuthError(w, 400, "unsupported_grant_type", "")
+		return
+	}
+
+	accessToken, err := cfg.issueJWT(usrId, oauthAccessTokenLifetime, client.Id, scopes)
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "Unable to create token")
+		return
+	}
+
+	recordAudit(r, usrId, auditOAuthToken, "oauth_client:"+client.Id, outcomeSuccess)
+	type tokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		Scope        string `json:"scope"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, 200, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	cases := []struct {
+		verifier  string
+		challenge string
+		expected  bool
+	}{
+		{
+			verifier:  "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk",
+			challenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+			expected:  true,
+		},
+		{
+			verifier:  "some-other-verifier",
+			challenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+			expected:  false,
+		},
+		{
+			verifier:  "",
+			challenge: "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU",
+			expected:  false,
+		},
+	}
+
+	for _, c := range cases {
+		actual := verifyPKCE(c.verifier, c.challenge)
+		if actual != c.expected {
+			t.Errorf("verifyPKCE(%v, %v) == %v, expected %v", c.verifier, c.challenge, actual, c.expected)
+		}
+	}
+}
+
+func TestAuthorizeDecisionHandle(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{
+		jwtSecret: "secret",
+		hasher:    newPasswordHasher(argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}),
+	}
+	hash, _ := cfg.hasher.hash("pwd")
+	dbHandle.CreateUser("usr@boot.dev", hash)
+	dbHandle.CreateOAuthClient(database.OAuthClient{Id: "client", Name: "app", RedirectURIs: []string{"https://app.example/callback"}})
+
+	cases := []struct {
+		name     string
+		email    string
+		password string
+		expected int
+	}{
+		{name: "correct password", email: "usr@boot.dev", password: "pwd", expected: 302},
+		{name: "email in another case", email: "USR@Boot.dev", password: "pwd", expected: 302},
+		{name: "wrong password", email: "usr@boot.dev", password: "wrong", expected: 401},
+		{name: "unknown email", email: "nobody@boot.dev", password: "pwd", expected: 401},
+	}
+
+	for _, c := range cases {
+		form := url.Values{
+			"client_id":             {"client"},
+			"redirect_uri":          {"https://app.example/callback"},
+			"response_type":         {"code"},
+			"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+			"code_challenge_method": {"S256"},
+			"scope":                 {scopeChirpsRead},
+			"decision":              {"approve"},
+			"email":                 {c.email},
+			"password":              {c.password},
+		}
+		req := httptest.NewRequest("POST", "/oauth/authorize", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		cfg.authorizeDecisionHandle(rec, req)
+
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d", c.name, rec.Code, c.expected)
+			continue
+		}
+		if c.expected == 302 && !strings.Contains(rec.Header().Get("Location"), "code=") {
+			t.Errorf("%s: redirect %s has no authorization code", c.name, rec.Header().Get("Location"))
+		}
+	}
+}
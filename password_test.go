@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	hasher := newPasswordHasher(argon2Params{
+		Memory:      8 * 1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	})
+
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte("legacy"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("unable to create bcrypt hash: %v", err)
+	}
+	currentHash, err := hasher.hash("current")
+	if err != nil {
+		t.Fatalf("unable to create argon2 hash: %v", err)
+	}
+	weakHash, err := newPasswordHasher(argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}).hash("weak")
+	if err != nil {
+		t.Fatalf("unable to create argon2 hash: %v", err)
+	}
+
+	cases := []struct {
+		password      string
+		encoded       string
+		expectedMatch bool
+		expectedWeak  bool
+	}{
+		{password: "legacy", encoded: string(legacyHash), expectedMatch: true, expectedWeak: true},
+		{password: "wrong", encoded: string(legacyHash), expectedMatch: false, expectedWeak: true},
+		{password: "current", encoded: currentHash, expectedMatch: true, expectedWeak: false},
+		{password: "wrong", encoded: currentHash, expectedMatch: false, expectedWeak: false},
+		{password: "weak", encoded: weakHash, expectedMatch: true, expectedWeak: true},
+	}
+
+	for _, c := range cases {
+		match, err := hasher.verify(c.password, c.encoded)
+		if err != nil {
+			t.Errorf("unable to verify %v: %v", c.password, err)
+			continue
+		}
+		if match != c.expectedMatch {
+			t.Errorf("verify(%v) == %v, expected %v", c.password, match, c.expectedMatch)
+		}
+		if weak := hasher.needsRehash(c.encoded); weak != c.expectedWeak {
+			t.Errorf("needsRehash(%v) == %v, expected %v", c.encoded, weak, c.expectedWeak)
+		}
+	}
+}
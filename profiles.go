@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+var handleRegex = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+type publicProfile struct {
+	Id          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	RedStatus   bool   `json:"is_chirpy_red"`
+	Followers   int    `json:"followers_count"`
+	Following   int    `json:"following_count"`
+}
+
+// authorSummary is the compact author object embedded in chirp responses
+type authorSummary struct {
+	Id          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+func newPublicProfile(usr database.User) publicProfile {
+	return publicProfile{
+		Id:          usr.Id,
+		Handle:      usr.Handle,
+		DisplayName: usr.DisplayName,
+		Bio:         usr.Bio,
+		AvatarURL:   usr.AvatarURL,
+		RedStatus:   usr.RedStatus,
+	}
+}
+
+func newAuthorSummary(usr database.User) *authorSummary {
+	return &authorSummary{
+		Id:          usr.Id,
+		Handle:      usr.Handle,
+		DisplayName: usr.DisplayName,
+		AvatarURL:   usr.AvatarURL,
+	}
+}
+
+// normalizeHandle strips a leading @ and validates the remaining handle
+func normalizeHandle(handle string) (string, error) {
+	handle = strings.TrimPrefix(strings.TrimSpace(handle), "@")
+	if !handleRegex.MatchString(handle) {
+		return "", fmt.Errorf("handle must be 1-15 letters, numbers or underscores")
+	}
+	return handle, nil
+}
+
+func validateProfile(displayName string, bio string, avatarURL string) error {
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return fmt.Errorf("display name must be at most %d characters", maxDisplayNameLength)
+	}
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return fmt.Errorf("bio must be at most %d characters", maxBioLength)
+	}
+	if avatarURL != "" {
+		parsed, err := url.Parse(avatarURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("avatar url must be an http or https url")
+		}
+	}
+	return nil
+}
+
+func getProfileHandle(w http.ResponseWriter, r *http.Request) {
+	handle, err := normalizeHandle(r.PathValue("handle"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+
+	usr, err := dbHandle.GetUserByHandle(handle)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	profile := newPublicProfile(usr)
+	profile.Followers, profile.Following, err = followCounts(dbHandle, usr.Id)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 200, profile)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func (cfg *apiConfig) editChirpHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	chirpId, err := strconv.Atoi(r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp id")
+		return
+	}
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+	chirpBody := normalizeChirpBody(params.Body)
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	existing, err := dbHandle.GetChirp(chirpId)
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	if existing.Author != info.UserId {
+		respondWithError(w, 403, "Only the author can edit a chirp")
+		return
+	}
+	if existing.RechirpOf != 0 {
+		respondWithError(w, 400, "Rechirps can't be edited")
+		return
+	}
+	// like when posting, a chirp with attachments doesn't need a body
+	if chirpBody == "" && len(existing.MediaIds) == 0 {
+		respondWithError(w, 400, "Chirp is empty")
+		return
+	}
+
+	author, err := dbHandle.GetUser(info.UserId)
+	if err != nil {
+		respondWithError(w, 401, "User not found")
+		return
+	}
+	filtered, err := cfg.checkChirpBody(author, chirpBody)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	mentions, err := resolveMentions(dbHandle, info.UserId, filtered.Body)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	chirp, err := dbHandle.EditChirp(chirpId, info.UserId, database.Chirp{
+		Body:       filtered.Body,
+		Tags:       extractHashtags(filtered.Body),
+		Mentions:   mentions,
+		Moderation: filtered.moderation(),
+	}, cfg.editWindow)
+	if errors.Is(err, database.ErrEditWindowClosed) {
+		respondWithError(w, 403, "The edit window for this chirp has closed")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	respondWithChirp(w, 200, dbHandle, chirp, info.UserId)
+}
+
+func (cfg *apiConfig) chirpHistoryHandle(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := strconv.Atoi(r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp id")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	chirp, err := dbHandle.GetChirp(chirpId)
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	if viewerId := cfg.viewerId(r); viewerId != 0 {
+		blocked, err := dbHandle.IsBlocked(viewerId, chirp.Author)
+		if err != nil {
+			respondWithError(w, 500, "Unable to obtain data from db")
+			return
+		}
+		if blocked {
+			respondWithError(w, 404, "Chirp not found")
+			return
+		}
+	}
+	revisions, err := dbHandle.GetChirpRevisions(chirpId)
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	type revisionResponse struct {
+		Body       string    `json:"body"`
+		CreatedAt  time.Time `json:"created_at"`
+		ReplacedAt time.Time `json:"replaced_at"`
+	}
+	resp := []revisionResponse{}
+	for _, revision := range revisions {
+		resp = append(resp, revisionResponse{
+			Body:       revision.Body,
+			CreatedAt:  revision.CreatedAt,
+			ReplacedAt: revision.ReplacedAt,
+		})
+	}
+	respondWithJSON(w, 200, resp)
+}
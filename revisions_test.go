@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestEditChirpHandle(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := newChirpConfig(t)
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	other, _ := dbHandle.CreateUser("other@boot.dev", "pwd")
+	dbHandle.CreateMedia(database.Media{Id: "photo", OwnerId: usr.Id})
+
+	text, _ := dbHandle.CreateChirp("text", usr.Id)
+	photo, _ := dbHandle.InsertChirp(database.Chirp{Body: "caption", Author: usr.Id, MediaIds: []string{"photo"}})
+	old, _ := dbHandle.InsertChirp(database.Chirp{Body: "old", Author: usr.Id, CreatedAt: time.Now().UTC().Add(-time.Hour)})
+	rechirp, _ := dbHandle.InsertChirp(database.Chirp{Author: usr.Id, RechirpOf: text.Id})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/chirps/{chirpId}", cfg.editChirpHandle)
+	mux.HandleFunc("GET /api/chirps/{chirpId}/history", cfg.chirpHistoryHandle)
+
+	cases := []struct {
+		name         string
+		id           int
+		body         string
+		auth         string
+		expected     int
+		expectedBody string
+	}{
+		{name: "edit", id: text.Id, body: "edited", auth: bearer(t, cfg, usr.Id), expected: 200, expectedBody: "edited"},
+		{name: "masked", id: text.Id, body: "a fornax", auth: bearer(t, cfg, usr.Id), expected: 200, expectedBody: "a ****"},
+		{name: "flagged", id: text.Id, body: "a sharbert", auth: bearer(t, cfg, usr.Id), expected: 200, expectedBody: "a sharbert"},
+		{name: "rejected", id: text.Id, body: "buy spam", auth: bearer(t, cfg, usr.Id), expected: 400},
+		{name: "empty", id: text.Id, body: " ", auth: bearer(t, cfg, usr.Id), expected: 400},
+		{name: "empty with media", id: photo.Id, body: "", auth: bearer(t, cfg, usr.Id), expected: 200, expectedBody: ""},
+		{name: "window closed", id: old.Id, body: "late", auth: bearer(t, cfg, usr.Id), expected: 403},
+		{name: "not the author", id: text.Id, body: "mine now", auth: bearer(t, cfg, other.Id), expected: 403},
+		{name: "rechirp", id: rechirp.Id, body: "body", auth: bearer(t, cfg, usr.Id), expected: 400},
+		{name: "unknown chirp", id: 99, body: "body", auth: bearer(t, cfg, usr.Id), expected: 404},
+	}
+
+	for _, c := range cases {
+		body, _ := json.Marshal(map[string]string{"body": c.body})
+		req := httptest.NewRequest("PUT", "/api/chirps/"+strconv.Itoa(c.id), strings.NewReader(string(body)))
+		req.Header.Set("Authorization", c.auth)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d: %s", c.name, rec.Code, c.expected, rec.Body.String())
+			continue
+		}
+		if c.expected != 200 {
+			continue
+		}
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(rec.Body.Bytes(), &fields)
+		if _, ok := fields["moderation"]; ok {
+			t.Errorf("%s: response exposes moderation: %s", c.name, rec.Body.String())
+		}
+		if string(fields["body"]) != strconv.Quote(c.expectedBody) {
+			t.Errorf("%s: body == %s, expected %q", c.name, fields["body"], c.expectedBody)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/chirps/"+strconv.Itoa(text.Id)+"/history", nil))
+	history := []struct {
+		Body string `json:"body"`
+	}{}
+	json.Unmarshal(rec.Body.Bytes(), &history)
+	expected := []string{"text", "edited", "a ****"}
+	if len(history) != len(expected) {
+		t.Fatalf("history length == %d, expected %d: %s", len(history), len(expected), rec.Body.String())
+	}
+	for i, revision := range history {
+		if revision.Body != expected[i] {
+			t.Errorf("revision %d body == %s, expected %s", i, revision.Body, expected[i])
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// requireRole only lets through requests with a valid token belonging to a user
+// with at least the given role, the user is stored on the request context
+func (cfg *apiConfig) requireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		usrId, err := cfg.userIdFromRequest(r)
+		if err != nil {
+			respondWithAuthError(w, err, 401)
+			return
+		}
+
+		dbHandle, err := database.NewDB("./database.json")
+		if err != nil {
+			respondWithError(w, 500, "Unable to connect to database")
+			return
+		}
+		usr, err := dbHandle.GetUser(usrId)
+		if err != nil {
+			respondWithError(w, 401, "User not found")
+			return
+		}
+
+		if database.RoleRank(usr.Role) < database.RoleRank(role) {
+			respondWithError(w, 403, "Insufficient role")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, usr)))
+	}
+}
+
+// userFromContext returns the user stored by requireRole
+func userFromContext(r *http.Request) (database.User, bool) {
+	usr, ok := r.Context().Value(userContextKey).(database.User)
+	return usr, ok
+}
+
+// bootstrapAdmin promotes the account matching ADMIN_EMAIL on startup so a
+// fresh install has someone able to hand out roles. Once there is an admin
+// it does nothing, roles are then only changed through the admin endpoints.
+func (cfg *apiConfig) bootstrapAdmin(dbHandle *database.DB) {
+	if cfg.adminEmail == "" {
+		return
+	}
+	usr, promoted, err := dbHandle.BootstrapAdmin(cfg.adminEmail)
+	if err != nil {
+		log.Printf("Unable to bootstrap an admin: %s", err)
+		return
+	}
+	if promoted {
+		log.Printf("Promoted user %d to admin", usr.Id)
+	}
+}
+
+func (cfg *apiConfig) setRoleHandle(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	role := strings.ToLower(strings.TrimSpace(params.Role))
+	if !database.IsValidRole(role) {
+		respondWithError(w, 400, fmt.Sprintf("Unknown role %s", params.Role))
+		return
+	}
+	cfg.changeRole(w, r, role)
+}
+
+func (cfg *apiConfig) revokeRoleHandle(w http.ResponseWriter, r *http.Request) {
+	cfg.changeRole(w, r, database.RoleUser)
+}
+
+func (cfg *apiConfig) changeRole(w http.ResponseWriter, r *http.Request, role string) {
+	actor, ok := userFromContext(r)
+	if !ok {
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
+
+	targetId, err := strconv.Atoi(r.PathValue("userId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+	if targetId == actor.Id && role != database.RoleAdmin {
+		respondWithError(w, 400, "Admins cannot revoke their own role")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+
+	usr, err := dbHandle.SetUserRole(actor.Id, targetId, role)
+	if err != nil {
+		recordAudit(r, actor.Id, auditRoleChange, userTarget(targetId), outcomeFailure)
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	recordAudit(r, actor.Id, auditRoleChange, userTarget(targetId), outcomeSuccess)
+
+	type roleResponse struct {
+		Id   int    `json:"id"`
+		Role string `json:"role"`
+	}
+	respondWithJSON(w, 200, roleResponse{Id: usr.Id, Role: usr.Role})
+}
+
+func roleAuditHandle(w http.ResponseWriter, r *http.Request) {
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+
+	changes, err := dbHandle.GetRoleChanges()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 200, changes)
+}
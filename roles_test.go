@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+// useTempDB runs the rest of the test in an empty directory, so handlers
+// opening ./database.json and ./audit.log get a fresh database
+func useTempDB(t *testing.T) *database.DB {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("unable to get working directory: %s", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("unable to change directory: %s", err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	return dbHandle
+}
+
+func bearer(t *testing.T, cfg *apiConfig, usrId int) string {
+	token, err := cfg.issueJWT(usrId, time.Hour, "", nil)
+	if err != nil {
+		t.Fatalf("unable to issue token: %s", err)
+	}
+	return "Bearer " + token
+}
+
+func TestRequireRole(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{jwtSecret: "secret"}
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	mod, _ := dbHandle.CreateUser("mod@boot.dev", "pwd")
+	admin, _ := dbHandle.CreateUser("admin@boot.dev", "pwd")
+	gone, _ := dbHandle.CreateUser("gone@boot.dev", "pwd")
+	dbHandle.SetUserRole(0, mod.Id, database.RoleModerator)
+	dbHandle.SetUserRole(0, admin.Id, database.RoleAdmin)
+	dbHandle.SetUserRole(0, gone.Id, database.RoleAdmin)
+	goneAuth := bearer(t, cfg, gone.Id)
+	dbHandle.DeleteUser(gone.Id, database.AnonymizeChirps)
+
+	cases := []struct {
+		name     string
+		role     string
+		auth     string
+		expected int
+	}{
+		{name: "no token", role: database.RoleModerator, auth: "", expected: 401},
+		{name: "bad token", role: database.RoleModerator, auth: "Bearer nonsense", expected: 401},
+		{name: "deleted admin", role: database.RoleModerator, auth: goneAuth, expected: 401},
+		{name: "user", role: database.RoleModerator, auth: bearer(t, cfg, usr.Id), expected: 403},
+		{name: "moderator", role: database.RoleModerator, auth: bearer(t, cfg, mod.Id), expected: 200},
+		{name: "admin as moderator", role: database.RoleModerator, auth: bearer(t, cfg, admin.Id), expected: 200},
+		{name: "moderator as admin", role: database.RoleAdmin, auth: bearer(t, cfg, mod.Id), expected: 403},
+		{name: "admin", role: database.RoleAdmin, auth: bearer(t, cfg, admin.Id), expected: 200},
+	}
+
+	for _, c := range cases {
+		var seen database.User
+		handler := cfg.requireRole(c.role, func(w http.ResponseWriter, r *http.Request) {
+			seen, _ = userFromContext(r)
+			w.WriteHeader(200)
+		})
+		req := httptest.NewRequest("GET", "/admin", nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d", c.name, rec.Code, c.expected)
+		}
+		if c.expected == 200 && database.RoleRank(seen.Role) < database.RoleRank(c.role) {
+			t.Errorf("%s: user on the context has role %q", c.name, seen.Role)
+		}
+	}
+}
+
+func TestChangeRoleHandles(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{jwtSecret: "secret"}
+	admin, _ := dbHandle.CreateUser("admin@boot.dev", "pwd")
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	dbHandle.SetUserRole(0, admin.Id, database.RoleAdmin)
+	adminAuth := bearer(t, cfg, admin.Id)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /admin/users/{userId}/role", cfg.requireRole(database.RoleAdmin, cfg.setRoleHandle))
+	mux.HandleFunc("DELETE /admin/users/{userId}/role", cfg.requireRole(database.RoleAdmin, cfg.revokeRoleHandle))
+
+	cases := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		auth         string
+		expected     int
+		expectedRole string
+	}{
+		{name: "grant moderator", method: "PUT", target: strconv.Itoa(usr.Id), body: `{"role":" Moderator "}`, auth: adminAuth, expected: 200, expectedRole: database.RoleModerator},
+		{name: "unknown role", method: "PUT", target: strconv.Itoa(usr.Id), body: `{"role":"owner"}`, auth: adminAuth, expected: 400, expectedRole: database.RoleModerator},
+		{name: "unknown user", method: "PUT", target: "99", body: `{"role":"admin"}`, auth: adminAuth, expected: 404},
+		{name: "not an admin", method: "PUT", target: strconv.Itoa(admin.Id), body: `{"role":"user"}`, auth: bearer(t, cfg, usr.Id), expected: 403},
+		{name: "revoke", method: "DELETE", target: strconv.Itoa(usr.Id), auth: adminAuth, expected: 200, expectedRole: database.RoleUser},
+		{name: "revoke own role", method: "DELETE", target: strconv.Itoa(admin.Id), auth: adminAuth, expected: 400},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/admin/users/"+c.target+"/role", strings.NewReader(c.body))
+		req.Header.Set("Authorization", c.auth)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d: %s", c.name, rec.Code, c.expected, rec.Body.String())
+		}
+		if c.expectedRole != "" {
+			if stored, _ := dbHandle.GetUser(usr.Id); stored.Role != c.expectedRole {
+				t.Errorf("%s: role == %s, expected %s", c.name, stored.Role, c.expectedRole)
+			}
+		}
+	}
+
+	if stored, _ := dbHandle.GetUser(admin.Id); stored.Role != database.RoleAdmin {
+		t.Errorf("admin role == %s after the failed changes", stored.Role)
+	}
+	changes, _ := dbHandle.GetRoleChanges()
+	// the admin's own promotion, the grant and the revoke
+	if len(changes) != 3 {
+		t.Errorf("role change count == %d, expected 3", len(changes))
+	}
+}
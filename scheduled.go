@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+// maxScheduleAhead is how far in the future a chirp may be scheduled
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+type scheduledChirpResponse struct {
+	database.Chirp
+	// Id is the id of the scheduled chirp, the chirp gets its own id once
+	// it is published
+	Id        int             `json:"id"`
+	PublishAt time.Time       `json:"publish_at"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Media     []mediaResponse `json:"media,omitempty"`
+	// Moderation shadows the field on the embedded chirp the same way
+	// chirpResponse does, authors don't see what the filters matched
+	Moderation *struct{} `json:"moderation,omitempty"`
+}
+
+func buildScheduledResponses(dbHandle *database.DB, scheduled []database.ScheduledChirp) ([]scheduledChirpResponse, error) {
+	allMedia, err := dbHandle.GetAllMedia()
+	if err != nil {
+		return nil, err
+	}
+	resp := []scheduledChirpResponse{}
+	for _, item := range scheduled {
+		response := scheduledChirpResponse{
+			Chirp:     item.Chirp,
+			Id:        item.Id,
+			PublishAt: item.PublishAt,
+			Status:    item.Status,
+			Error:     item.Error,
+			CreatedAt: item.CreatedAt,
+		}
+		for _, id := range item.Chirp.MediaIds {
+			if media, ok := allMedia[id]; ok {
+				response.Media = append(response.Media, newMediaResponse(media))
+			}
+		}
+		resp = append(resp, response)
+	}
+	return resp, nil
+}
+
+// checkPublishAt responds with 400 and returns false when a chirp can't be
+// scheduled for publishAt
+func checkPublishAt(w http.ResponseWriter, publishAt time.Time) bool {
+	now := time.Now()
+	if !publishAt.After(now) {
+		respondWithError(w, 400, "publish_at must be in the future")
+		return false
+	}
+	if publishAt.After(now.Add(maxScheduleAhead)) {
+		respondWithError(w, 400, fmt.Sprintf("Chirps can be scheduled at most %d days ahead", int(maxScheduleAhead.Hours()/24)))
+		return false
+	}
+	return true
+}
+
+// scheduleChirp stores a draft to be published at publishAt. The draft is
+// checked now, the same way a chirp posted straight away is, and its length
+// and content are checked again when it is published.
+func (cfg *apiConfig) scheduleChirp(w http.ResponseWriter, usrId int, draft chirpDraft, publishAt time.Time) {
+	if !checkPublishAt(w, publishAt) {
+		return
+	}
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	chirp, ok := cfg.composeChirp(w, dbHandle, usrId, draft)
+	if !ok {
+		return
+	}
+	scheduled, err := dbHandle.ScheduleChirp(database.ScheduledChirp{
+		Chirp:        chirp,
+		OriginalBody: normalizeChirpBody(draft.Body),
+		PublishAt:    publishAt.UTC(),
+	})
+	if errors.Is(err, database.ErrInvalidMedia) {
+		respondWithError(w, 400, "Attachments must be your own uploads that aren't attached to another chirp")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Unable to write to database")
+		return
+	}
+	resp, err := buildScheduledResponses(dbHandle, []database.ScheduledChirp{scheduled})
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 201, resp[0])
+}
+
+func (cfg *apiConfig) listScheduledHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	scheduled, err := dbHandle.GetScheduledChirps(info.UserId)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	resp, err := buildScheduledResponses(dbHandle, scheduled)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) getScheduledHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	scheduledId, err := strconv.Atoi(r.PathValue("scheduledId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid scheduled chirp id")
+		return
+	}
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	scheduled, err := dbHandle.GetScheduledChirp(scheduledId, info.UserId)
+	if err != nil {
+		respondWithError(w, 404, "Scheduled chirp not found")
+		return
+	}
+	resp, err := buildScheduledResponses(dbHandle, []database.ScheduledChirp{scheduled})
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 200, resp[0])
+}
+
+// editScheduledHandle replaces the content of a scheduled chirp, publish_at
+// may be left out to keep the current time
+func (cfg *apiConfig) editScheduledHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	scheduledId, err := strconv.Atoi(r.PathValue("scheduledId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid scheduled chirp id")
+		return
+	}
+	type parameters struct {
+		chirpDraft
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	existing, err := dbHandle.GetScheduledChirp(scheduledId, info.UserId)
+	if err != nil {
+		respondWithError(w, 404, "Scheduled chirp not found")
+		return
+	}
+	publishAt := existing.PublishAt
+	if params.PublishAt != nil {
+		publishAt = params.PublishAt.UTC()
+	}
+	if !checkPublishAt(w, publishAt) {
+		return
+	}
+	chirp, ok := cfg.composeChirp(w, dbHandle, info.UserId, params.chirpDraft)
+	if !ok {
+		return
+	}
+
+	scheduled, err := dbHandle.UpdateScheduledChirp(scheduledId, info.UserId, database.ScheduledChirp{
+		Chirp:        chirp,
+		OriginalBody: normalizeChirpBody(params.Body),
+		PublishAt:    publishAt,
+	})
+	if errors.Is(err, database.ErrInvalidMedia) {
+		respondWithError(w, 400, "Attachments must be your own uploads that aren't attached to another chirp")
+		return
+	}
+	if errors.Is(err, database.ErrScheduledNotFound) {
+		// published or cancelled since we looked it up
+		respondWithError(w, 404, "Scheduled chirp not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Unable to write to database")
+		return
+	}
+	resp, err := buildScheduledResponses(dbHandle, []database.ScheduledChirp{scheduled})
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 200, resp[0])
+}
+
+func (cfg *apiConfig) cancelScheduledHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	scheduledId, err := strconv.Atoi(r.PathValue("scheduledId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid scheduled chirp id")
+		return
+	}
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	err = dbHandle.CancelScheduledChirp(scheduledId, info.UserId)
+	if errors.Is(err, database.ErrScheduledNotFound) {
+		respondWithError(w, 404, "Scheduled chirp not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Unable to write to database")
+		return
+	}
+	respondWithJSON(w, 204, "")
+}
+
+// runScheduler publishes scheduled chirps that are due straight away and
+// then every interval. Scheduled chirps are stored in the database, so any
+// that came due while the server was down are published on start.
+func (cfg *apiConfig) runScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		dbHandle, err := database.NewDB("./database.json")
+		if err == nil {
+			err = cfg.publishDueChirps(dbHandle, time.Now().UTC())
+		}
+		if err != nil {
+			log.Printf("Unable to publish scheduled chirps: %s", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (cfg *apiConfig) publishDueChirps(dbHandle *database.DB, now time.Time) error {
+	due, err := dbHandle.GetDueScheduledChirps(now)
+	if err != nil {
+		return err
+	}
+	for _, scheduled := range due {
+		_, err := dbHandle.PublishScheduledChirp(scheduled.Id, now, cfg.recheckScheduled)
+		if errors.Is(err, database.ErrScheduledNotFound) {
+			// cancelled or moved to a later time since we looked
+			continue
+		}
+		if err != nil {
+			log.Printf("Unable to publish scheduled chirp %d: %s", scheduled.Id, err)
+		}
+	}
+	return nil
+}
+
+// recheckScheduled runs a scheduled chirp through the length limit and the
+// content filters again as it is published, either may have changed since
+// it was scheduled. The mentions are left to be resolved against the
+// filtered body.
+func (cfg *apiConfig) recheckScheduled(author database.User, scheduled database.ScheduledChirp) (database.Chirp, []database.MentionCandidate, error) {
+	chirp := scheduled.Chirp
+	body := scheduled.OriginalBody
+	if body == "" {
+		body = chirp.Body
+	}
+	filtered, err := cfg.checkChirpBody(author, body)
+	if err != nil {
+		return database.Chirp{}, nil, err
+	}
+	chirp.Body = filtered.Body
+	chirp.Tags = extractHashtags(filtered.Body)
+	chirp.Moderation = filtered.moderation()
+	return chirp, mentionCandidates(filtered.Body), nil
+}
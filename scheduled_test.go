@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestPublishDueChirpsRechecks(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := newChirpConfig(t)
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	now := time.Now().UTC()
+
+	// the filters change between scheduling and publishing
+	configPath := t.TempDir() + "/filters.json"
+	os.WriteFile(configPath, []byte(`{"rules": [
+		{"word": "gopher", "action": "mask"},
+		{"word": "sharbert", "action": "flag"},
+		{"word": "spam", "action": "reject"}
+	]}`), 0644)
+	filter, err := newWordListFilter(configPath)
+	if err != nil {
+		t.Fatalf("unable to load filter: %v", err)
+	}
+	cfg.contentFilters = filterChain{filter}
+	cfg.chirpLimits = chirpLimits{Default: 10, Red: 10}
+
+	cases := []struct {
+		name           string
+		body           string
+		expectedBody   string
+		expectedFailed bool
+		expectedFlag   bool
+	}{
+		{name: "unchanged", body: "hello", expectedBody: "hello"},
+		{name: "newly masked", body: "a gopher", expectedBody: "a ****"},
+		{name: "no longer masked", body: "a fornax", expectedBody: "a fornax"},
+		{name: "flagged", body: "sharbert", expectedBody: "sharbert", expectedFlag: true},
+		{name: "newly rejected", body: "buy spam", expectedFailed: true},
+		{name: "limit lowered", body: strings.Repeat("a", 15), expectedFailed: true},
+	}
+
+	ids := map[int]int{}
+	for i, c := range cases {
+		scheduled, err := dbHandle.ScheduleChirp(database.ScheduledChirp{
+			Chirp:        database.Chirp{Body: c.body, Author: usr.Id},
+			OriginalBody: c.body,
+			PublishAt:    now.Add(time.Duration(i+1) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("%s: unable to schedule chirp: %v", c.name, err)
+		}
+		ids[scheduled.Id] = i
+	}
+
+	if err := cfg.publishDueChirps(dbHandle, now.Add(time.Hour)); err != nil {
+		t.Fatalf("unable to publish: %v", err)
+	}
+
+	published := map[string]database.Chirp{}
+	chirps, _ := dbHandle.GetChirps()
+	for _, chirp := range chirps {
+		published[chirp.Body] = chirp
+	}
+	failed := map[int]bool{}
+	remaining, _ := dbHandle.GetScheduledChirps(usr.Id)
+	for _, scheduled := range remaining {
+		if scheduled.Status != database.ScheduledFailed || scheduled.Error == "" {
+			t.Errorf("scheduled chirp %d is %s (%s), expected it to have failed", scheduled.Id, scheduled.Status, scheduled.Error)
+		}
+		failed[ids[scheduled.Id]] = true
+	}
+
+	for i, c := range cases {
+		if failed[i] != c.expectedFailed {
+			t.Errorf("%s: failed == %v, expected %v", c.name, failed[i], c.expectedFailed)
+			continue
+		}
+		if c.expectedFailed {
+			continue
+		}
+		chirp, ok := published[c.expectedBody]
+		if !ok {
+			t.Errorf("%s: no chirp published with body %q", c.name, c.expectedBody)
+			continue
+		}
+		flagged := chirp.Moderation != nil && chirp.Moderation.Flagged
+		if flagged != c.expectedFlag {
+			t.Errorf("%s: flagged == %v, expected %v", c.name, flagged, c.expectedFlag)
+		}
+	}
+}
+
+func TestScheduledHandlesHideModeration(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := newChirpConfig(t)
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	scheduled, _ := dbHandle.ScheduleChirp(database.ScheduledChirp{
+		Chirp: database.Chirp{
+			Body:       "a sharbert",
+			Author:     usr.Id,
+			Moderation: &database.Moderation{Flagged: true, Matches: []database.FilterMatch{{Filter: "words", Rule: "sharbert", Action: "flag"}}},
+		},
+		OriginalBody: "a sharbert",
+		PublishAt:    time.Now().Add(time.Hour),
+	})
+	auth := bearer(t, cfg, usr.Id)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/scheduled_chirps", cfg.listScheduledHandle)
+	mux.HandleFunc("GET /api/scheduled_chirps/{scheduledId}", cfg.getScheduledHandle)
+	mux.HandleFunc("PUT /api/scheduled_chirps/{scheduledId}", cfg.editScheduledHandle)
+
+	cases := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: "GET", target: "/api/scheduled_chirps"},
+		{method: "GET", target: fmt.Sprintf("/api/scheduled_chirps/%d", scheduled.Id)},
+		{method: "PUT", target: fmt.Sprintf("/api/scheduled_chirps/%d", scheduled.Id), body: `{"body":"another sharbert"}`},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != 200 {
+			t.Errorf("%s %s: status == %d, expected 200: %s", c.method, c.target, rec.Code, rec.Body.String())
+			continue
+		}
+		if strings.Contains(rec.Body.String(), "moderation") || strings.Contains(rec.Body.String(), "flagged") {
+			t.Errorf("%s %s: response exposes moderation: %s", c.method, c.target, rec.Body.String())
+		}
+	}
+}
+
+func TestPublishDueChirpsResolvesMentions(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := newChirpConfig(t)
+	cfg.chirpLimits = chirpLimits{Default: 140, Red: 140}
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	fan, _ := dbHandle.InsertUser(database.User{Email: "fan@boot.dev", Password: "pwd", Handle: "fan"})
+	blocker, _ := dbHandle.InsertUser(database.User{Email: "blocker@boot.dev", Password: "pwd", Handle: "blocker"})
+	now := time.Now().UTC()
+
+	// the mentions were resolved against the unfiltered body and before the block
+	body := "a fornax @fan @blocker"
+	scheduled, err := dbHandle.ScheduleChirp(database.ScheduledChirp{
+		Chirp: database.Chirp{
+			Body:     body,
+			Author:   usr.Id,
+			Mentions: []database.Mention{{UserId: fan.Id, Start: 9, End: 13}, {UserId: blocker.Id, Start: 14, End: 22}},
+		},
+		OriginalBody: body,
+		PublishAt:    now.Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("unable to schedule chirp: %v", err)
+	}
+	dbHandle.BlockUser(blocker.Id, usr.Id)
+
+	if err := cfg.publishDueChirps(dbHandle, now.Add(time.Hour)); err != nil {
+		t.Fatalf("unable to publish: %v", err)
+	}
+	if remaining, _ := dbHandle.GetScheduledChirps(usr.Id); len(remaining) != 0 {
+		t.Fatalf("scheduled chirp %d wasn't published: %+v", scheduled.Id, remaining)
+	}
+	chirps, _ := dbHandle.GetChirps()
+	var published database.Chirp
+	for _, chirp := range chirps {
+		if chirp.Author == usr.Id {
+			published = chirp
+		}
+	}
+
+	if len(published.Mentions) != 1 || published.Mentions[0].UserId != fan.Id {
+		t.Fatalf("mentions == %+v, expected only @fan", published.Mentions)
+	}
+	mention := published.Mentions[0]
+	if text := string([]rune(published.Body)[mention.Start:mention.End]); text != "@fan" {
+		t.Errorf("mention covers %q in %q, expected @fan", text, published.Body)
+	}
+	if groups, _, _ := dbHandle.GetNotificationGroups(blocker.Id, false, 0, 10); len(groups) != 0 {
+		t.Errorf("blocker notifications == %+v, expected none", groups)
+	}
+}
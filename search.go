@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+const maxSearchQueryLength = 500
+
+// parseSearchQuery understands plain words, "quoted phrases", #hashtags and
+// the from:handle, since:date and until:date operators, dates are either
+// YYYY-MM-DD or RFC 3339 and until is exclusive
+func parseSearchQuery(dbHandle *database.DB, q string) (database.SearchQuery, error) {
+	query := database.SearchQuery{}
+	words := []string{}
+	for i, part := range strings.Split(q, `"`) {
+		// every odd part sits between a pair of quotes
+		if i%2 == 1 {
+			if phrase := database.QueryTerms(part); len(phrase) > 0 {
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+		words = append(words, strings.Fields(part)...)
+	}
+
+	for _, word := range words {
+		operator, value, found := strings.Cut(word, ":")
+		switch {
+		case found && operator == "from":
+			usr, err := dbHandle.GetUserByHandle(strings.TrimPrefix(value, "@"))
+			if err != nil {
+				return database.SearchQuery{}, fmt.Errorf("unknown user %s", value)
+			}
+			query.AuthorId = usr.Id
+		case found && (operator == "since" || operator == "until"):
+			date, err := parseSearchDate(value)
+			if err != nil {
+				return database.SearchQuery{}, err
+			}
+			if operator == "since" {
+				query.Since = &date
+			} else {
+				query.Until = &date
+			}
+		default:
+			query.Terms = append(query.Terms, database.QueryTerms(word)...)
+		}
+	}
+	return query, nil
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %s", value)
+	}
+	return date, nil
+}
+
+func (cfg *apiConfig) searchHandle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if strings.TrimSpace(q) == "" || len(q) > maxSearchQueryLength {
+		respondWithError(w, 400, "Search query is missing or too long")
+		return
+	}
+	limit, offset, ok := offsetPageParams(r)
+	if !ok {
+		respondWithError(w, 400, "Invalid pagination parameters")
+		return
+	}
+	sortMethod := r.URL.Query().Get("sort")
+	if sortMethod != "" && sortMethod != "relevance" && sortMethod != "recent" {
+		respondWithError(w, 400, "sort must be relevance or recent")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	query, err := parseSearchQuery(dbHandle, q)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if authorId := r.URL.Query().Get("author_id"); authorId != "" {
+		query.AuthorId, err = strconv.Atoi(authorId)
+		if err != nil {
+			respondWithError(w, 400, "Invalid author id")
+			return
+		}
+	}
+
+	hits, err := dbHandle.SearchChirps(query)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	view := cfg.chirpViewFor(r)
+	// hidden chirps are dropped before paging so pages stay full
+	restrictions, err := loadViewerRestrictions(dbHandle, view.ViewerId)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	visible := []database.SearchHit{}
+	for _, hit := range hits {
+		if !restrictions.hides(hit.Chirp.Author, false) {
+			visible = append(visible, hit)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		if sortMethod != "recent" && visible[i].Score != visible[j].Score {
+			return visible[i].Score > visible[j].Score
+		}
+		return visible[i].Chirp.Id > visible[j].Chirp.Id
+	})
+
+	// offset is clamped before adding to it so a huge offset can't overflow
+	start := min(offset, len(visible))
+	end := start + min(limit, len(visible)-start)
+	chirps := []database.Chirp{}
+	for _, hit := range visible[start:end] {
+		chirps = append(chirps, hit.Chirp)
+	}
+	resp, err := buildChirpResponses(dbHandle, chirps, view)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	type searchResponse struct {
+		Chirps     []chirpResponse `json:"chirps"`
+		Total      int             `json:"total"`
+		NextOffset int             `json:"next_offset,omitempty"`
+	}
+	page := searchResponse{Chirps: resp, Total: len(visible)}
+	if end < len(visible) {
+		page.NextOffset = end
+	}
+	respondWithJSON(w, 200, page)
+}
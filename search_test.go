@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	dbHandle, err := database.NewDB(t.TempDir() + "/db.json")
+	if err != nil {
+		t.Fatalf("unable to create db: %s", err)
+	}
+	usr, _ := dbHandle.InsertUser(database.User{Email: "usr@boot.dev", Password: "pwd", Handle: "gopher"})
+	may := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	june := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		input     string
+		expected  database.SearchQuery
+		expectErr bool
+	}{
+		{input: "Go FUN", expected: database.SearchQuery{Terms: []string{"go", "fun"}}},
+		{input: "#Go", expected: database.SearchQuery{Terms: []string{"#go"}}},
+		{input: `"learning go" fun`, expected: database.SearchQuery{Terms: []string{"fun"}, Phrases: [][]string{{"learning", "go"}}}},
+		{input: `"" "  " fun`, expected: database.SearchQuery{Terms: []string{"fun"}}},
+		{input: `"unclosed phrase`, expected: database.SearchQuery{Phrases: [][]string{{"unclosed", "phrase"}}}},
+		{input: "from:gopher go", expected: database.SearchQuery{Terms: []string{"go"}, AuthorId: usr.Id}},
+		{input: "from:@Gopher", expected: database.SearchQuery{AuthorId: usr.Id}},
+		{input: "from:nobody", expectErr: true},
+		{input: "since:2024-05-01 until:2024-06-01T12:30:00Z", expected: database.SearchQuery{Since: &may, Until: &june}},
+		{input: "since:yesterday", expectErr: true},
+		{input: "note:taken", expected: database.SearchQuery{Terms: []string{"note", "taken"}}},
+	}
+
+	for _, c := range cases {
+		actual, err := parseSearchQuery(dbHandle, c.input)
+		if (err != nil) != c.expectErr {
+			t.Errorf("parseSearchQuery(%s) error == %v, expected error: %v", c.input, err, c.expectErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("parseSearchQuery(%s) == %+v, expected %+v", c.input, actual, c.expected)
+		}
+	}
+}
+
+func TestSearchHandlePages(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{jwtSecret: "secret"}
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	for i := 0; i < 5; i++ {
+		dbHandle.CreateChirp(fmt.Sprintf("gopher number %d", i), usr.Id)
+	}
+	dbHandle.CreateChirp("nothing to see", usr.Id)
+
+	cases := []struct {
+		query         string
+		expected      int
+		expectedCount int
+		expectedNext  int
+	}{
+		{query: "limit=2", expected: 200, expectedCount: 2, expectedNext: 2},
+		{query: "limit=2&offset=4", expected: 200, expectedCount: 1},
+		{query: "limit=100&offset=5", expected: 200, expectedCount: 0},
+		{query: "offset=9223372036854775807", expected: 200, expectedCount: 0},
+		{query: "limit=100&offset=9223372036854775807", expected: 200, expectedCount: 0},
+		{query: "offset=-1", expected: 400},
+		{query: "limit=abc", expected: 400},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		cfg.searchHandle(rec, httptest.NewRequest("GET", "/api/search?q="+url.QueryEscape("gopher")+"&"+c.query, nil))
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d", c.query, rec.Code, c.expected)
+			continue
+		}
+		if c.expected != 200 {
+			continue
+		}
+		page := struct {
+			Chirps     []json.RawMessage `json:"chirps"`
+			Total      int               `json:"total"`
+			NextOffset int               `json:"next_offset"`
+		}{}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		if len(page.Chirps) != c.expectedCount || page.Total != 5 || page.NextOffset != c.expectedNext {
+			t.Errorf("%s: %d chirps of %d, next %d, expected %d of 5, next %d",
+				c.query, len(page.Chirps), page.Total, page.NextOffset, c.expectedCount, c.expectedNext)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+// tagHandle lists the chirps carrying a hashtag, newest first
+func (cfg *apiConfig) tagHandle(w http.ResponseWriter, r *http.Request) {
+	tag := normalizeHashtag(r.PathValue("tag"))
+	if tag == "" {
+		respondWithError(w, 400, "Invalid hashtag")
+		return
+	}
+	limit, before, ok := pageParams(r)
+	if !ok {
+		respondWithError(w, 400, "Invalid pagination parameters")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	count, err := dbHandle.GetTagCount(tag)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	// chirps by blocked authors are skipped before the page is cut
+	page, err := fillChirpPage(dbHandle, cfg.chirpViewFor(r), before, limit, func(before int, limit int) ([]database.Chirp, int, error) {
+		return dbHandle.GetChirpsByTag(tag, before, limit)
+	})
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	type tagResponse struct {
+		Tag   string `json:"tag"`
+		Count int    `json:"count"`
+		chirpPage
+	}
+	respondWithJSON(w, 200, tagResponse{
+		Tag:       tag,
+		Count:     count,
+		chirpPage: page,
+	})
+}
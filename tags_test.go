@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestTagHandleSkipsBlockedAuthors(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{jwtSecret: "secret"}
+	viewer, _ := dbHandle.CreateUser("viewer@boot.dev", "pwd")
+	author, _ := dbHandle.CreateUser("author@boot.dev", "pwd")
+	blocked, _ := dbHandle.CreateUser("blocked@boot.dev", "pwd")
+	tagged := []int{}
+	for i := 0; i < 3; i++ {
+		chirp, _ := dbHandle.InsertChirp(database.Chirp{Body: "#go", Author: author.Id, Tags: []string{"go"}})
+		tagged = append(tagged, chirp.Id)
+	}
+	for i := 0; i < 3; i++ {
+		dbHandle.InsertChirp(database.Chirp{Body: "#go", Author: blocked.Id, Tags: []string{"go"}})
+	}
+	dbHandle.BlockUser(viewer.Id, blocked.Id)
+	auth := bearer(t, cfg, viewer.Id)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tags/{tag}", cfg.tagHandle)
+
+	cases := []struct {
+		query        string
+		auth         string
+		expectedIds  []int
+		expectedNext int
+	}{
+		{query: "limit=2", auth: auth, expectedIds: []int{tagged[2], tagged[1]}, expectedNext: tagged[1]},
+		{query: fmt.Sprintf("limit=2&before=%d", tagged[1]), auth: auth, expectedIds: []int{tagged[0]}},
+		{query: "limit=10", auth: auth, expectedIds: []int{tagged[2], tagged[1], tagged[0]}},
+		// blocks only hide chirps from the two users involved
+		{query: "limit=10", expectedIds: []int{tagged[2] + 3, tagged[2] + 2, tagged[2] + 1, tagged[2], tagged[1], tagged[0]}},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/api/tags/go?"+c.query, nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != 200 {
+			t.Errorf("%s: status == %d, expected 200", c.query, rec.Code)
+			continue
+		}
+
+		page := struct {
+			Chirps []struct {
+				Id int `json:"id"`
+			} `json:"chirps"`
+			NextCursor int `json:"next_cursor"`
+		}{}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		ids := []int{}
+		for _, chirp := range page.Chirps {
+			ids = append(ids, chirp.Id)
+		}
+		if !slices.Equal(ids, c.expectedIds) || page.NextCursor != c.expectedNext {
+			t.Errorf("%s: page == %v next %d, expected %v next %d", c.query, ids, page.NextCursor, c.expectedIds, c.expectedNext)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// chirpLimits is the maximum chirp length in grapheme clusters per user tier
+type chirpLimits struct {
+	Default int
+	Red     int
+}
+
+func (l chirpLimits) forUser(redStatus bool) int {
+	if redStatus {
+		return l.Red
+	}
+	return l.Default
+}
+
+// normalizeChirpBody strips control and invisible formatting characters,
+// keeping the joiners and tag characters that emoji sequences need, and
+// puts what is left into NFC. Stripping comes first so a hidden character
+// can't keep a letter and its combining mark from being composed.
+func normalizeChirpBody(body string) string {
+	body = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case r == '\u200c' || r == '\u200d':
+			return r
+		case r >= '\U000e0020' && r <= '\U000e007f':
+			return r
+		case unicode.Is(unicode.Cc, r), unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, body)
+	return strings.TrimSpace(norm.NFC.String(body))
+}
+
+// chirpLength counts user-perceived characters rather than bytes or runes
+func chirpLength(body string) int {
+	return uniseg.GraphemeClusterCount(body)
+}
+
+// extractHashtags returns the normalized hashtags in a chirp body in the order
+// they first appear. A tag starts with # at the start of a word and runs for as
+// long as there are word characters, tags made up of only digits don't count.
+func extractHashtags(body string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for i := 0; i < len(body); i++ {
+		if body[i] != '#' {
+			continue
+		}
+		if i > 0 {
+			before, _ := utf8.DecodeLastRuneInString(body[:i])
+			if isWordRune(before) || before == '#' {
+				continue
+			}
+		}
+		end := i + 1
+		for end < len(body) {
+			r, size := utf8.DecodeRuneInString(body[end:])
+			if !isWordRune(r) {
+				break
+			}
+			end += size
+		}
+		tag := normalizeHashtag(body[i+1 : end])
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+		i = end - 1
+	}
+	return tags
+}
+
+// normalizeHashtag case folds a tag so #Go and #GO are the same tag, it returns
+// an empty string for anything that isn't a valid tag
+func normalizeHashtag(tag string) string {
+	tag = strings.TrimPrefix(tag, "#")
+	if tag == "" || strings.IndexFunc(tag, func(r rune) bool { return !isWordRune(r) }) != -1 {
+		return ""
+	}
+	if strings.IndexFunc(tag, func(r rune) bool { return !unicode.IsDigit(r) }) == -1 {
+		return ""
+	}
+	return norm.NFC.String(cases.Fold().String(tag))
+}
+
+// mentionCandidate is an @handle in a chirp body, Start and End are rune
+// offsets covering the leading @
+type mentionCandidate struct {
+	Handle string
+	Start  int
+	End    int
+}
+
+// extractMentions finds the @handles in a chirp body, an @ in the middle of a
+// word such as an email address doesn't start a mention
+func extractMentions(body string) []mentionCandidate {
+	mentions := []mentionCandidate{}
+	runes := []rune(body)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && (isWordRune(runes[i-1]) || runes[i-1] == '@')) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		if handle := string(runes[i+1 : end]); handleRegex.MatchString(handle) {
+			mentions = append(mentions, mentionCandidate{Handle: handle, Start: i, End: end})
+		}
+		i = end - 1
+	}
+	return mentions
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChirpLength(t *testing.T) {
+	cases := []struct {
+		input          string
+		expectedBody   string
+		expectedLength int
+	}{
+		{input: "hello", expectedBody: "hello", expectedLength: 5},
+		{input: strings.Repeat("😀", 50), expectedBody: strings.Repeat("😀", 50), expectedLength: 50},
+		{input: "cafe\u0301", expectedBody: "caf\u00e9", expectedLength: 4},
+		{input: "👩\u200d👩\u200d👧", expectedBody: "👩\u200d👩\u200d👧", expectedLength: 1},
+		{input: "🇳🇱", expectedBody: "🇳🇱", expectedLength: 1},
+		{input: "in\u200bvis\u2066ible\u0007", expectedBody: "invisible", expectedLength: 9},
+		{input: "e\u200b\u0301t\u00ade\u0301", expectedBody: "\u00e9t\u00e9", expectedLength: 3},
+		{input: "  two\nlines  ", expectedBody: "two\nlines", expectedLength: 9},
+	}
+
+	for _, c := range cases {
+		actual := normalizeChirpBody(c.input)
+		if actual != c.expectedBody {
+			t.Errorf("normalizeChirpBody(%q) == %q, expected %q", c.input, actual, c.expectedBody)
+		}
+		if length := chirpLength(actual); length != c.expectedLength {
+			t.Errorf("chirpLength(%q) == %v, expected %v", actual, length, c.expectedLength)
+		}
+	}
+}
+
+func TestExtractHashtags(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{input: "no tags here", expected: []string{}},
+		{input: "#Go is #fun, #go", expected: []string{"go", "fun"}},
+		{input: "issue#12 and #123 are not tags", expected: []string{}},
+		{input: "#Straße #café.", expected: []string{"strasse", "caf\u00e9"}},
+		{input: "##double #snake_case!", expected: []string{"snake_case"}},
+	}
+
+	for _, c := range cases {
+		actual := extractHashtags(c.input)
+		if strings.Join(actual, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("extractHashtags(%q) == %v, expected %v", c.input, actual, c.expected)
+		}
+	}
+}
+
+func TestExtractMentions(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []mentionCandidate
+	}{
+		{input: "hi @bob", expected: []mentionCandidate{{Handle: "bob", Start: 3, End: 7}}},
+		{input: "😀 @Al_1, @al_1!", expected: []mentionCandidate{{Handle: "Al_1", Start: 2, End: 7}, {Handle: "al_1", Start: 9, End: 14}}},
+		{input: "mail me at bob@boot.dev", expected: []mentionCandidate{}},
+		{input: "@this_handle_is_too_long @@double", expected: []mentionCandidate{}},
+	}
+
+	for _, c := range cases {
+		actual := extractMentions(c.input)
+		if fmt.Sprint(actual) != fmt.Sprint(c.expected) {
+			t.Errorf("extractMentions(%q) == %v, expected %v", c.input, actual, c.expected)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+// maxThreadDepth guards the walk up to the root of a conversation
+const maxThreadDepth = 1000
+
+// threadNode is one chirp in a conversation tree, a deleted chirp that
+// still has replies is kept as a node without a chirp, as is a chirp
+// hidden from the viewer by a block
+type threadNode struct {
+	Id      int            `json:"id"`
+	Deleted bool           `json:"deleted"`
+	Hidden  bool           `json:"hidden,omitempty"`
+	Chirp   *chirpResponse `json:"chirp,omitempty"`
+	Replies []*threadNode  `json:"replies"`
+}
+
+func (cfg *apiConfig) repliesHandle(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := strconv.Atoi(r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp id")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	chirps, err := dbHandle.GetChirps()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	found := false
+	replies := []database.Chirp{}
+	for _, chirp := range chirps {
+		if chirp.Id == chirpId {
+			found = true
+		}
+		if chirp.ReplyTo == chirpId {
+			replies = append(replies, chirp)
+		}
+	}
+	// replies to a deleted chirp can still be listed
+	if !found && len(replies) == 0 {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	sort.Slice(replies, func(i, j int) bool {
+		return replies[i].Id < replies[j].Id
+	})
+
+	resp, err := buildChirpResponses(dbHandle, replies, cfg.chirpViewFor(r))
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) threadHandle(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := strconv.Atoi(r.PathValue("chirpId"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid chirp id")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	chirps, err := dbHandle.GetChirps()
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+
+	// the tree is built from every chirp so that hiding one
+	// doesn't detach the replies below it
+	byId := map[int]database.Chirp{}
+	parents := map[int]int{}
+	children := map[int][]int{}
+	for _, chirp := range chirps {
+		byId[chirp.Id] = chirp
+		parents[chirp.Id] = chirp.ReplyTo
+		if chirp.ReplyTo != 0 {
+			children[chirp.ReplyTo] = append(children[chirp.ReplyTo], chirp.Id)
+		}
+	}
+	if _, ok := byId[chirpId]; !ok {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	// walk up to the root, which may be a deleted chirp
+	rootId := chirpId
+	for depth := 0; depth < maxThreadDepth; depth++ {
+		parentId, ok := parents[rootId]
+		if !ok || parentId == 0 {
+			break
+		}
+		rootId = parentId
+	}
+
+	// only the chirps in this thread are decorated for the viewer
+	thread := []database.Chirp{}
+	for _, id := range collectThread(rootId, children, 0) {
+		if chirp, ok := byId[id]; ok {
+			thread = append(thread, chirp)
+		}
+	}
+	resp, err := buildChirpResponses(dbHandle, thread, cfg.chirpViewFor(r))
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	visible := map[int]*chirpResponse{}
+	for i := range resp {
+		visible[resp[i].Id] = &resp[i]
+	}
+	if _, ok := visible[chirpId]; !ok {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+
+	respondWithJSON(w, 200, buildThread(rootId, visible, parents, children, 0))
+}
+
+// collectThread returns id and the ids of every reply below it, down to
+// the same depth buildThread renders
+func collectThread(id int, children map[int][]int, depth int) []int {
+	ids := []int{id}
+	if depth >= maxThreadDepth {
+		return ids
+	}
+	for _, replyId := range children[id] {
+		ids = append(ids, collectThread(replyId, children, depth+1)...)
+	}
+	return ids
+}
+
+func buildThread(id int, visible map[int]*chirpResponse, parents map[int]int, children map[int][]int, depth int) *threadNode {
+	node := &threadNode{Id: id, Replies: []*threadNode{}}
+	if chirp, ok := visible[id]; ok {
+		node.Chirp = chirp
+	} else if _, ok := parents[id]; ok {
+		node.Hidden = true
+	} else {
+		node.Deleted = true
+	}
+	if depth >= maxThreadDepth {
+		return node
+	}
+
+	replyIds := children[id]
+	sort.Ints(replyIds)
+	for _, replyId := range replyIds {
+		node.Replies = append(node.Replies, buildThread(replyId, visible, parents, children, depth+1))
+	}
+	return node
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestThreadHandle(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{jwtSecret: "secret"}
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", "pwd")
+	root, _ := dbHandle.CreateChirp("root", usr.Id)
+	reply, _ := dbHandle.InsertChirp(database.Chirp{Body: "reply", Author: usr.Id, ReplyTo: root.Id})
+	nested, _ := dbHandle.InsertChirp(database.Chirp{Body: "nested", Author: usr.Id, ReplyTo: reply.Id})
+	other, _ := dbHandle.CreateChirp("other thread", usr.Id)
+	dbHandle.InsertChirp(database.Chirp{Body: "other reply", Author: usr.Id, ReplyTo: other.Id})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpId}/thread", cfg.threadHandle)
+
+	cases := []struct {
+		name     string
+		chirpId  int
+		expected int
+	}{
+		{name: "root", chirpId: root.Id, expected: 200},
+		{name: "nested reply", chirpId: nested.Id, expected: 200},
+		{name: "unknown", chirpId: 99, expected: 404},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/chirps/"+strconv.Itoa(c.chirpId)+"/thread", nil))
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d", c.name, rec.Code, c.expected)
+			continue
+		}
+		if c.expected != 200 {
+			continue
+		}
+
+		node := threadNode{}
+		json.Unmarshal(rec.Body.Bytes(), &node)
+		if node.Id != root.Id || node.Chirp == nil || len(node.Replies) != 1 {
+			t.Errorf("%s: thread root == %+v, expected chirp %d with one reply", c.name, node, root.Id)
+			continue
+		}
+		replyNode := node.Replies[0]
+		if replyNode.Id != reply.Id || len(replyNode.Replies) != 1 || replyNode.Replies[0].Id != nested.Id || replyNode.Replies[0].Chirp == nil {
+			t.Errorf("%s: thread doesn't hold the reply chain %d -> %d", c.name, reply.Id, nested.Id)
+		}
+	}
+}
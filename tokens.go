@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+type accessTokenResponse struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Token is only ever filled in on the response that creates it
+	Token string `json:"token,omitempty"`
+}
+
+func newAccessTokenResponse(token database.AccessToken) accessTokenResponse {
+	return accessTokenResponse{
+		Id:        token.Id,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+	}
+}
+
+func (cfg *apiConfig) createAccessTokenHandle(w http.ResponseWriter, r *http.Request) {
+	usrId, err := cfg.userIdFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	type parameters struct {
+		Name       string   `json:"name"`
+		Scopes     []string `json:"scopes"`
+		Expiration int      `json:"expires_in_seconds"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	name := strings.TrimSpace(params.Name)
+	if name == "" {
+		respondWithError(w, 400, "Token name is required")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, 400, "At least one scope is required")
+		return
+	}
+	scopes := []string{}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(grantableScopes, scope) {
+			respondWithError(w, 400, fmt.Sprintf("Unknown scope %s", scope))
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	randomData := make([]byte, 32)
+	_, err = rand.Read(randomData)
+	if err != nil {
+		respondWithError(w, 500, "Unable to create token")
+		return
+	}
+	tokenStr := accessTokenPrefix + hex.EncodeToString(randomData)
+	idData := make([]byte, 6)
+	_, err = rand.Read(idData)
+	if err != nil {
+		respondWithError(w, 500, "Unable to create token")
+		return
+	}
+
+	token := database.AccessToken{
+		Id:        hex.EncodeToString(idData),
+		UserId:    usrId,
+		Name:      name,
+		Hash:      hashSecret(tokenStr),
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if params.Expiration > 0 {
+		expiration := token.CreatedAt.Add(time.Duration(params.Expiration) * time.Second)
+		token.ExpiresAt = &expiration
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	token, err = dbHandle.CreateAccessToken(token)
+	if errors.Is(err, database.ErrUserNotFound) {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 500, "Unable to write to database")
+		return
+	}
+
+	recordAudit(r, usrId, auditAccessTokenCreate, "access_token:"+token.Id, outcomeSuccess)
+	resp := newAccessTokenResponse(token)
+	resp.Token = tokenStr
+	respondWithJSON(w, 201, resp)
+}
+
+func (cfg *apiConfig) listAccessTokensHandle(w http.ResponseWriter, r *http.Request) {
+	usrId, err := cfg.userIdFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	tokens, err := dbHandle.GetAccessTokens(usrId)
+	if err != nil {
+		respondWithError(w, 500, "Unable to obtain data from db")
+		return
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	resp := []accessTokenResponse{}
+	for _, token := range tokens {
+		resp = append(resp, newAccessTokenResponse(token))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) revokeAccessTokenHandle(w http.ResponseWriter, r *http.Request) {
+	usrId, err := cfg.userIdFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	target := "access_token:" + r.PathValue("tokenId")
+	err = dbHandle.DeleteAccessToken(usrId, r.PathValue("tokenId"))
+	if err != nil {
+		recordAudit(r, usrId, auditAccessTokenRevoke, target, outcomeFailure)
+		respondWithError(w, 404, "Token not found")
+		return
+	}
+	recordAudit(r, usrId, auditAccessTokenRevoke, target, outcomeSuccess)
+	respondWithJSON(w, 204, "")
+}
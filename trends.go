@@ -0,0 +1,277 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+const (
+	// maxTrendCandidates are kept per window so that trends can still be
+	// filled after leaving out authors the viewer has blocked
+	maxTrendCandidates = 100
+	defaultTrendLimit  = 10
+	maxTrendLimit      = 50
+	// minTrendAuthors keeps a single account from making something trend
+	minTrendAuthors = 2
+	minTermLength   = 3
+)
+
+// trendWindow is a sliding window trends are computed over, a chirp's weight
+// halves every quarter of the window so recent chirps count the most
+type trendWindow struct {
+	Name   string
+	Length time.Duration
+}
+
+var trendWindows = []trendWindow{
+	{Name: "1h", Length: time.Hour},
+	{Name: "24h", Length: 24 * time.Hour},
+}
+
+var trendStopWords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`the and for are but not you all any can had her was one our out
+		day get has him his how man new now old see two way who boy did its let put say she too use
+		that with have this will your from they know want been good much some time very when come here
+		just like long make many more only over such take than them well were what about would there
+		their which could other these after first where into also really`) {
+		trendStopWords[word] = true
+	}
+}
+
+type trend struct {
+	Term       string  `json:"term"`
+	Score      float64 `json:"score"`
+	ChirpCount int     `json:"chirp_count"`
+	// authorScores is each author's contribution to the score
+	authorScores map[int]float64
+	authorChirps map[int]int
+}
+
+type trendList struct {
+	Hashtags []trend
+	Terms    []trend
+}
+
+type trendSnapshot struct {
+	GeneratedAt time.Time
+	Windows     map[string]trendList
+}
+
+// trendStore holds the latest snapshot computed by the trends worker
+type trendStore struct {
+	mux      *sync.RWMutex
+	snapshot trendSnapshot
+}
+
+func newTrendStore() *trendStore {
+	return &trendStore{mux: &sync.RWMutex{}}
+}
+
+func (s *trendStore) get() trendSnapshot {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.snapshot
+}
+
+func (s *trendStore) set(snapshot trendSnapshot) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.snapshot = snapshot
+}
+
+// runTrendsWorker recomputes trends straight away and then every interval
+func (cfg *apiConfig) runTrendsWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		dbHandle, err := database.NewDB("./database.json")
+		if err == nil {
+			var chirps []database.Chirp
+			chirps, err = dbHandle.GetChirps()
+			if err == nil {
+				cfg.trends.set(computeTrends(chirps, time.Now().UTC()))
+			}
+		}
+		if err != nil {
+			log.Printf("Unable to compute trends: %s", err)
+		}
+		<-ticker.C
+	}
+}
+
+// computeTrends scores hashtags and terms in every trend window. Chirps the
+// content filters touched are left out, and each author counts once per
+// term with the weight of their most recent chirp.
+func computeTrends(chirps []database.Chirp, now time.Time) trendSnapshot {
+	snapshot := trendSnapshot{GeneratedAt: now, Windows: map[string]trendList{}}
+	for _, window := range trendWindows {
+		hashtags := map[string]*trend{}
+		terms := map[string]*trend{}
+		halfLife := window.Length / 4
+		for _, chirp := range chirps {
+			age := now.Sub(chirp.CreatedAt)
+			if age < 0 || age > window.Length || chirp.Moderation != nil {
+				continue
+			}
+			weight := math.Pow(0.5, float64(age)/float64(halfLife))
+			for _, tag := range chirp.Tags {
+				addTrendEvent(hashtags, tag, chirp.Author, weight)
+			}
+			for _, term := range trendTerms(chirp.Body) {
+				addTrendEvent(terms, term, chirp.Author, weight)
+			}
+		}
+		snapshot.Windows[window.Name] = trendList{
+			Hashtags: rankTrends(hashtags, nil, maxTrendCandidates),
+			Terms:    rankTrends(terms, nil, maxTrendCandidates),
+		}
+	}
+	return snapshot
+}
+
+func addTrendEvent(trends map[string]*trend, term string, author int, weight float64) {
+	item, ok := trends[term]
+	if !ok {
+		item = &trend{Term: term, authorScores: map[int]float64{}, authorChirps: map[int]int{}}
+		trends[term] = item
+	}
+	item.authorChirps[author]++
+	item.authorScores[author] = max(item.authorScores[author], weight)
+}
+
+// trendTerms are the distinct words of a chirp worth trending on, words
+// written as hashtags are already counted as hashtags
+func trendTerms(body string) []string {
+	tokens := database.Tokenize(body)
+	tagged := map[int]bool{}
+	for _, token := range tokens {
+		if strings.HasPrefix(token.Term, "#") {
+			tagged[token.Position] = true
+		}
+	}
+
+	terms := []string{}
+	seen := map[string]bool{}
+	for _, token := range tokens {
+		term := token.Term
+		if tagged[token.Position] || seen[term] || trendStopWords[term] || len([]rune(term)) < minTermLength {
+			continue
+		}
+		if strings.IndexFunc(term, func(r rune) bool { return !unicode.IsDigit(r) }) == -1 {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+// rankTrends scores candidates without the excluded authors and returns the
+// top limit that enough distinct authors contributed to
+func rankTrends(candidates map[string]*trend, excluded map[int]bool, limit int) []trend {
+	ranked := []trend{}
+	for _, candidate := range candidates {
+		item := *candidate
+		item.ChirpCount = 0
+		score := 0.0
+		authors := 0
+		for author, authorScore := range candidate.authorScores {
+			if excluded[author] {
+				continue
+			}
+			score += authorScore
+			item.ChirpCount += candidate.authorChirps[author]
+			authors++
+		}
+		if authors < minTrendAuthors {
+			continue
+		}
+		item.Score = math.Round(score*1000) / 1000
+		ranked = append(ranked, item)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Term < ranked[j].Term
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+func (cfg *apiConfig) trendsHandle(w http.ResponseWriter, r *http.Request) {
+	windowName := r.URL.Query().Get("window")
+	if windowName == "" {
+		windowName = trendWindows[len(trendWindows)-1].Name
+	}
+	limit := defaultTrendLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 {
+			respondWithError(w, 400, "Invalid limit")
+			return
+		}
+		limit = min(parsed, maxTrendLimit)
+	}
+
+	snapshot := cfg.trends.get()
+	list, ok := snapshot.Windows[windowName]
+	if !ok {
+		if snapshot.Windows == nil {
+			respondWithError(w, 503, "Trends are still being computed")
+			return
+		}
+		respondWithError(w, 400, "Unknown trend window")
+		return
+	}
+
+	// the snapshot is shared, so a viewer's blocks are applied when serving
+	excluded := map[int]bool{}
+	if viewerId := cfg.viewerId(r); viewerId != 0 {
+		dbHandle, err := database.NewDB("./database.json")
+		if err != nil {
+			respondWithError(w, 500, "Unable to connect to database")
+			return
+		}
+		restrictions, err := loadViewerRestrictions(dbHandle, viewerId)
+		if err != nil {
+			respondWithError(w, 500, "Unable to obtain data from db")
+			return
+		}
+		excluded = restrictions.blocked
+	}
+
+	type trendsResponse struct {
+		Window      string    `json:"window"`
+		GeneratedAt time.Time `json:"generated_at"`
+		Hashtags    []trend   `json:"hashtags"`
+		Terms       []trend   `json:"terms"`
+	}
+	respondWithJSON(w, 200, trendsResponse{
+		Window:      windowName,
+		GeneratedAt: snapshot.GeneratedAt,
+		Hashtags:    rankTrends(trendsByTerm(list.Hashtags), excluded, limit),
+		Terms:       rankTrends(trendsByTerm(list.Terms), excluded, limit),
+	})
+}
+
+func trendsByTerm(trends []trend) map[string]*trend {
+	byTerm := map[string]*trend{}
+	for i := range trends {
+		byTerm[trends[i].Term] = &trends[i]
+	}
+	return byTerm
+}
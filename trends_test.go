@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	database "github.com/zsolomon88/bootdev-chirpy/internal"
+)
+
+func TestComputeTrends(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	chirp := func(author int, body string, age time.Duration) database.Chirp {
+		return database.Chirp{Author: author, Body: body, Tags: extractHashtags(body), CreatedAt: now.Add(-age)}
+	}
+	chirps := []database.Chirp{
+		chirp(1, "#golang release day", 5*time.Minute),
+		chirp(2, "the #golang release is out", 10*time.Minute),
+		chirp(3, "#rust release notes", 50*time.Minute),
+		chirp(4, "#rust again", 55*time.Minute),
+		chirp(5, "#spam #spam", time.Minute),
+		chirp(5, "#spam", time.Minute),
+		chirp(6, "#old news", 2*time.Hour),
+		chirp(7, "#old story", 3*time.Hour),
+	}
+	chirps = append(chirps, database.Chirp{
+		Author: 8, Body: "#golang", Tags: []string{"golang"}, CreatedAt: now,
+		Moderation: &database.Moderation{Flagged: true},
+	})
+
+	cases := []struct {
+		window           string
+		excluded         map[int]bool
+		expectedHashtags string
+		expectedTerms    string
+	}{
+		{window: "1h", expectedHashtags: "[golang rust]", expectedTerms: "[release]"},
+		{window: "24h", expectedHashtags: "[golang rust old]", expectedTerms: "[release]"},
+		{window: "1h", excluded: map[int]bool{1: true}, expectedHashtags: "[rust]", expectedTerms: "[release]"},
+	}
+
+	snapshot := computeTrends(chirps, now)
+	for _, c := range cases {
+		list := snapshot.Windows[c.window]
+		hashtags := []string{}
+		for _, item := range rankTrends(trendsByTerm(list.Hashtags), c.excluded, 10) {
+			hashtags = append(hashtags, item.Term)
+		}
+		terms := []string{}
+		for _, item := range rankTrends(trendsByTerm(list.Terms), c.excluded, 10) {
+			terms = append(terms, item.Term)
+		}
+		if fmt.Sprint(hashtags) != c.expectedHashtags {
+			t.Errorf("%s hashtags == %v, expected %v", c.window, hashtags, c.expectedHashtags)
+		}
+		if fmt.Sprint(terms) != c.expectedTerms {
+			t.Errorf("%s terms == %v, expected %v", c.window, terms, c.expectedTerms)
+		}
+	}
+}
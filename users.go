@@ -2,21 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	database "github.com/zsolomon88/bootdev-chirpy/internal"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func (cfg *apiConfig) createUserHandle(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email       string `json:"email"`
+		Password    string `json:"password"`
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -28,7 +29,21 @@ func (cfg *apiConfig) createUserHandle(w http.ResponseWriter, r *http.Request) {
 	}
 	userEmail := strings.TrimSpace(params.Email)
 	userPassword := strings.TrimSpace(params.Password)
-	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(userPassword), bcrypt.DefaultCost)
+	displayName := strings.TrimSpace(params.DisplayName)
+	handle := ""
+	if strings.TrimSpace(params.Handle) != "" {
+		handle, err = normalizeHandle(params.Handle)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+	err = validateProfile(displayName, "", "")
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	hashedPwd, err := cfg.hasher.hash(userPassword)
 	if err != nil {
 		respondWithError(w, 500, "unable to create user")
 		return
@@ -39,108 +54,250 @@ func (cfg *apiConfig) createUserHandle(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 500, "Unable to connect to database")
 		return
 	}
-	usr, err := dbHandle.CreateUser(userEmail, string(hashedPwd))
+	usr, err := dbHandle.InsertUser(database.User{
+		Email:       userEmail,
+		Password:    hashedPwd,
+		Handle:      handle,
+		DisplayName: displayName,
+	})
+	if err == database.ErrEmailTaken {
+		respondWithError(w, 409, "Email already in use")
+		return
+	}
+	if err == database.ErrHandleTaken {
+		respondWithError(w, 409, "Handle already taken")
+		return
+	}
 	if err != nil {
 		respondWithError(w, 500, "Unable to write to database")
 		return
 	}
+	recordAudit(r, usr.Id, auditUserCreate, userTarget(usr.Id), outcomeSuccess)
 	type UserReply struct {
-		Id        int    `json:"id"`
-		Email     string `json:"email"`
-		RedStatus bool   `json:"is_chirpy_red"`
+		Id          int    `json:"id"`
+		Email       string `json:"email"`
+		RedStatus   bool   `json:"is_chirpy_red"`
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name"`
 	}
 	jsonReply := UserReply{
-		Id:        usr.Id,
-		Email:     usr.Email,
-		RedStatus: usr.RedStatus,
+		Id:          usr.Id,
+		Email:       usr.Email,
+		RedStatus:   usr.RedStatus,
+		Handle:      usr.Handle,
+		DisplayName: usr.DisplayName,
 	}
 	respondWithJSON(w, 201, jsonReply)
 }
 
 func (cfg *apiConfig) updateUsrHandle(w http.ResponseWriter, r *http.Request) {
-	authToken := r.Header.Get("Authorization")
-	tokenParts := strings.Split(authToken, " ")
+	usrId, err := cfg.userIdFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	type parameters struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
 
-	if len(tokenParts) != 2 {
-		respondWithError(w, 403, "Invalid token recieved")
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 500, "Something went wrong")
 		return
 	}
-	if tokenParts[0] != "Bearer" {
-		respondWithError(w, 403, "Invalid token type")
+	userEmail := strings.TrimSpace(params.Email)
+	userPassword := strings.TrimSpace(params.Password)
+	if userEmail == "" || userPassword == "" {
+		respondWithError(w, 400, "Email and password are required")
 		return
 	}
 
-	tokenStr := tokenParts[1]
+	hashedPwd, err := cfg.hasher.hash(userPassword)
+	if err != nil {
+		respondWithError(w, 500, "unable to create user")
+		return
+	}
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
 
-	tokenClaims := &jwt.RegisteredClaims{}
-	plainToken, err := jwt.ParseWithClaims(tokenStr, tokenClaims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+	currentUser, err := dbHandle.GetUser(usrId)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
 
-		return []byte(cfg.jwtSecret), nil
-	})
+	updatedUserInfo := currentUser
+	updatedUserInfo.Email = userEmail
+	updatedUserInfo.Password = hashedPwd
+	_, err = dbHandle.UpdateUser(usrId, updatedUserInfo)
+	if err == database.ErrEmailTaken {
+		respondWithError(w, 409, "Email already in use")
+		return
+	}
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("Incorrect token: %s", err))
+		respondWithError(w, 500, "Unable to write to database")
 		return
 	}
-	usrId, err := plainToken.Claims.GetSubject()
+	recordAudit(r, usrId, auditPasswordChange, userTarget(usrId), outcomeSuccess)
+	if currentUser.Email != updatedUserInfo.Email {
+		recordAudit(r, usrId, auditEmailChange, userTarget(usrId), outcomeSuccess)
+	}
+
+	type updateResponse struct {
+		Email     string `json:"email"`
+		Id        int    `json:"id"`
+		RedStatus bool   `json:"is_chirpy_red"`
+	}
+	respondWithJSON(w, 200, updateResponse{Email: updatedUserInfo.Email, Id: updatedUserInfo.Id, RedStatus: updatedUserInfo.RedStatus})
+}
+
+func (cfg *apiConfig) patchUsrHandle(w http.ResponseWriter, r *http.Request) {
+	info, err := cfg.authorize(r, scopeProfileWrite)
 	if err != nil {
-		respondWithError(w, 401, "Incorrect token")
+		respondWithAuthError(w, err, 401)
 		return
 	}
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		Handle          *string `json:"handle"`
+		DisplayName     *string `json:"display_name"`
+		Bio             *string `json:"bio"`
+		AvatarURL       *string `json:"avatar_url"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
 	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, 500, "Something went wrong")
+		respondWithError(w, 400, "Invalid request body")
 		return
 	}
-	userEmail := strings.TrimSpace(params.Email)
-	userPassword := strings.TrimSpace(params.Password)
 
-	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(userPassword), bcrypt.DefaultCost)
+	dbHandle, err := database.NewDB("./database.json")
 	if err != nil {
-		respondWithError(w, 500, "unable to create user")
+		respondWithError(w, 500, "Unable to connect to database")
 		return
 	}
-	dbHandle, err := database.NewDB("./database.json")
+	usr, err := dbHandle.GetUser(info.UserId)
 	if err != nil {
-		respondWithError(w, 500, "Unable to connect to database")
+		respondWithError(w, 404, "User not found")
 		return
 	}
 
-	userMap, err := dbHandle.GetUsers()
+	if params.Email != nil || params.Password != nil {
+		if !info.hasScope(scopeAccount) {
+			respondWithAuthError(w, errInsufficientScope, 401)
+			return
+		}
+		pwdMatch, err := cfg.hasher.verify(strings.TrimSpace(params.CurrentPassword), usr.Password)
+		if err != nil {
+			respondWithError(w, 500, "Unable to verify password")
+			return
+		}
+		if !pwdMatch {
+			if params.Password != nil {
+				recordAudit(r, usr.Id, auditPasswordChange, userTarget(usr.Id), outcomeDenied)
+			}
+			if params.Email != nil {
+				recordAudit(r, usr.Id, auditEmailChange, userTarget(usr.Id), outcomeDenied)
+			}
+			respondWithError(w, 403, "Current password is incorrect")
+			return
+		}
+	}
+
+	if params.Email != nil {
+		userEmail := strings.TrimSpace(*params.Email)
+		if userEmail == "" {
+			respondWithError(w, 400, "Email cannot be empty")
+			return
+		}
+		usr.Email = userEmail
+	}
+
+	if params.Password != nil {
+		userPassword := strings.TrimSpace(*params.Password)
+		if userPassword == "" {
+			respondWithError(w, 400, "Password cannot be empty")
+			return
+		}
+		hashedPwd, err := cfg.hasher.hash(userPassword)
+		if err != nil {
+			respondWithError(w, 500, "Unable to hash password")
+			return
+		}
+		usr.Password = hashedPwd
+	}
 
+	if params.Handle != nil {
+		handle, err := normalizeHandle(*params.Handle)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		usr.Handle = handle
+	}
+	if params.DisplayName != nil {
+		usr.DisplayName = strings.TrimSpace(*params.DisplayName)
+	}
+	if params.Bio != nil {
+		usr.Bio = strings.TrimSpace(*params.Bio)
+	}
+	if params.AvatarURL != nil {
+		usr.AvatarURL = strings.TrimSpace(*params.AvatarURL)
+	}
+	err = validateProfile(usr.DisplayName, usr.Bio, usr.AvatarURL)
 	if err != nil {
-		respondWithError(w, 500, "Unable to connect to database")
+		respondWithError(w, 400, err.Error())
 		return
 	}
 
-	intId, _ := strconv.Atoi(usrId)
-	updatedUserInfo := database.User{
-		Email:     userEmail,
-		Password:  string(hashedPwd),
-		Id:        intId,
-		RedStatus: userMap[intId].RedStatus,
+	usr, err = dbHandle.UpdateUser(usr.Id, usr)
+	if err == database.ErrEmailTaken {
+		respondWithError(w, 409, "Email already in use")
+		return
+	}
+	if err == database.ErrHandleTaken {
+		respondWithError(w, 409, "Handle already taken")
+		return
 	}
-	_, err = dbHandle.UpdateUser(intId, updatedUserInfo)
 	if err != nil {
 		respondWithError(w, 500, "Unable to write to database")
 		return
 	}
+	if params.Password != nil {
+		recordAudit(r, usr.Id, auditPasswordChange, userTarget(usr.Id), outcomeSuccess)
+	}
+	if params.Email != nil {
+		recordAudit(r, usr.Id, auditEmailChange, userTarget(usr.Id), outcomeSuccess)
+	}
 
 	type updateResponse struct {
-		Email     string `json:"email"`
-		Id        int    `json:"id"`
-		RedStatus bool   `json:"is_chirpy_red"`
+		Email       string `json:"email"`
+		Id          int    `json:"id"`
+		RedStatus   bool   `json:"is_chirpy_red"`
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name"`
+		Bio         string `json:"bio"`
+		AvatarURL   string `json:"avatar_url"`
 	}
-	respondWithJSON(w, 200, updateResponse{Email: updatedUserInfo.Email, Id: updatedUserInfo.Id})
+	respondWithJSON(w, 200, updateResponse{
+		Email:       usr.Email,
+		Id:          usr.Id,
+		RedStatus:   usr.RedStatus,
+		Handle:      usr.Handle,
+		DisplayName: usr.DisplayName,
+		Bio:         usr.Bio,
+		AvatarURL:   usr.AvatarURL,
+	})
 }
 
 func (cfg *apiConfig) refreshHandle(w http.ResponseWriter, r *http.Request) {
@@ -159,10 +316,12 @@ func (cfg *apiConfig) refreshHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentTime := time.Now()
-	expiration := time.Now().Add(time.Hour)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Issuer: "chirpy", IssuedAt: jwt.NewNumericDate(currentTime), ExpiresAt: jwt.NewNumericDate(expiration), Subject: fmt.Sprintf("%d", validToken.Id)})
-	signedToken, err := token.SignedString([]byte(cfg.jwtSecret))
+	if validToken.ClientId != "" {
+		respondWithError(w, 401, "Invalid refresh token: issued to an OAuth client")
+		return
+	}
+
+	signedToken, err := cfg.issueJWT(validToken.Id, time.Hour, "", nil)
 	if err != nil {
 		respondWithError(w, 500, "Unable to create token")
 		return
@@ -184,15 +343,50 @@ func (cfg *apiConfig) revokeTokenHandle(w http.ResponseWriter, r *http.Request)
 
 	refreshToken := r.Header.Get("Authorization")
 	refreshToken = strings.TrimPrefix(refreshToken, "Bearer ")
+	tokenInfo, _ := dbHandle.CheckRefreshToken(refreshToken)
+	// the token itself must not end up in the log, its hash still ties
+	// the entry to the session
+	target := "refresh_token:" + hashSecret(refreshToken)[:16]
 	err = dbHandle.DeleteToken(refreshToken)
 	if err != nil {
+		recordAudit(r, tokenInfo.Id, auditRefreshTokenRevoke, target, outcomeFailure)
 		respondWithError(w, 401, fmt.Sprintf("Invalid refresh token: %v", err))
 		return
 	}
 
+	recordAudit(r, tokenInfo.Id, auditRefreshTokenRevoke, target, outcomeSuccess)
 	respondWithJSON(w, 204, "")
 }
 
+var errInvalidLogin = errors.New("incorrect email or password")
+
+// findLoginUser returns the account whose email matches case-insensitively
+// and whose password is correct. Emails are unique, but accounts created
+// before that was enforced may share one, so every match gets its password
+// checked. On errInvalidLogin failedTarget names the attempt for the audit log
+func (cfg *apiConfig) findLoginUser(dbHandle *database.DB, email string, password string) (usr database.User, failedTarget string, err error) {
+	users, err := dbHandle.GetUsers()
+	if err != nil {
+		return database.User{}, "", err
+	}
+
+	failedTarget = "email:" + email
+	for _, innerUser := range users {
+		if !strings.EqualFold(innerUser.Email, email) {
+			continue
+		}
+		pwdMatch, err := cfg.hasher.verify(password, innerUser.Password)
+		if err != nil {
+			return database.User{}, "", err
+		}
+		if pwdMatch {
+			return innerUser, "", nil
+		}
+		failedTarget = userTarget(innerUser.Id)
+	}
+	return database.User{}, failedTarget, errInvalidLogin
+}
+
 func (cfg *apiConfig) authenticateHandle(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email      string `json:"email"`
@@ -221,50 +415,104 @@ func (cfg *apiConfig) authenticateHandle(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	users, err := dbHandle.GetUsers()
+	usr, failedTarget, err := cfg.findLoginUser(dbHandle, userEmail, userPassword)
+	if errors.Is(err, errInvalidLogin) {
+		recordAudit(r, 0, auditLogin, failedTarget, outcomeFailure)
+		respondWithError(w, 401, "Unauthorized")
+		return
+	}
 	if err != nil {
-		respondWithError(w, 500, "Unable to obtain data from db")
+		respondWithError(w, 500, "Unable to verify password")
 		return
 	}
 
-	for _, innerUser := range users {
-		if innerUser.Email == userEmail {
-			pwdMatch := bcrypt.CompareHashAndPassword([]byte(innerUser.Password), []byte(userPassword))
-			if pwdMatch == nil {
-				currentTime := time.Now()
-				expiration := time.Now().Add(expTime)
-				token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Issuer: "chirpy", IssuedAt: jwt.NewNumericDate(currentTime), ExpiresAt: jwt.NewNumericDate(expiration), Subject: fmt.Sprintf("%d", innerUser.Id)})
-				signedToken, err := token.SignedString([]byte(cfg.jwtSecret))
-				if err != nil {
-					respondWithError(w, 500, "Unable to create token")
-					return
-				}
-
-				refreshExpiration := time.Now().Add(60 * 24 * time.Hour)
-				refreshToken, err := dbHandle.CreateRefreshToken(refreshExpiration, innerUser.Id)
-				if err != nil {
-					respondWithError(w, 500, "Unable to create refresh token")
-					return
-				}
-				type UserResponse struct {
-					Id           int    `json:"id"`
-					Email        string `json:"email"`
-					Token        string `json:"token"`
-					RefreshToken string `json:"refresh_token"`
-					RedStatus    bool   `json:"is_chirpy_red"`
-				}
-				successResponse := UserResponse{
-					Id:           innerUser.Id,
-					Email:        innerUser.Email,
-					Token:        signedToken,
-					RefreshToken: refreshToken.Token,
-					RedStatus:    innerUser.RedStatus,
-				}
-				respondWithJSON(w, 200, successResponse)
-				return
-			}
+	if cfg.hasher.needsRehash(usr.Password) {
+		rehashed, err := cfg.hasher.hash(userPassword)
+		if err == nil {
+			err = dbHandle.UpgradePasswordHash(usr.Id, usr.Password, rehashed)
 		}
+		// a password changed since it was verified keeps its new hash
+		if err != nil && !errors.Is(err, database.ErrPasswordChanged) {
+			log.Printf("Unable to upgrade password hash for user %d: %s", usr.Id, err)
+		}
+	}
+
+	signedToken, err := cfg.issueJWT(usr.Id, expTime, "", nil)
+	if err != nil {
+		respondWithError(w, 500, "Unable to create token")
+		return
 	}
 
-	respondWithError(w, 401, "Unauthorized")
+	refreshExpiration := time.Now().Add(60 * 24 * time.Hour)
+	refreshToken, err := dbHandle.CreateRefreshToken(refreshExpiration, usr.Id)
+	if err != nil {
+		respondWithError(w, 500, "Unable to create refresh token")
+		return
+	}
+	type UserResponse struct {
+		Id           int    `json:"id"`
+		Email        string `json:"email"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		RedStatus    bool   `json:"is_chirpy_red"`
+	}
+	successResponse := UserResponse{
+		Id:           usr.Id,
+		Email:        usr.Email,
+		Token:        signedToken,
+		RefreshToken: refreshToken.Token,
+		RedStatus:    usr.RedStatus,
+	}
+	recordAudit(r, usr.Id, auditLogin, userTarget(usr.Id), outcomeSuccess)
+	respondWithJSON(w, 200, successResponse)
+}
+
+func (cfg *apiConfig) deleteUserHandle(w http.ResponseWriter, r *http.Request) {
+	usrId, err := cfg.userIdFromRequest(r)
+	if err != nil {
+		respondWithAuthError(w, err, 401)
+		return
+	}
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	dbHandle, err := database.NewDB("./database.json")
+	if err != nil {
+		respondWithError(w, 500, "Unable to connect to database")
+		return
+	}
+	usr, err := dbHandle.GetUser(usrId)
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+
+	pwdMatch, err := cfg.hasher.verify(strings.TrimSpace(params.Password), usr.Password)
+	if err != nil {
+		respondWithError(w, 500, "Unable to verify password")
+		return
+	}
+	if !pwdMatch {
+		recordAudit(r, usr.Id, auditUserDelete, userTarget(usr.Id), outcomeDenied)
+		respondWithError(w, 403, "Password is incorrect")
+		return
+	}
+
+	err = dbHandle.DeleteUser(usr.Id, cfg.deletionPolicy)
+	if err != nil {
+		recordAudit(r, usr.Id, auditUserDelete, userTarget(usr.Id), outcomeFailure)
+		respondWithError(w, 500, fmt.Sprintf("DB error: %v", err))
+		return
+	}
+	recordAudit(r, usr.Id, auditUserDelete, userTarget(usr.Id), outcomeSuccess)
+	respondWithJSON(w, 204, "")
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticateHandle(t *testing.T) {
+	dbHandle := useTempDB(t)
+	cfg := &apiConfig{
+		jwtSecret: "secret",
+		hasher:    newPasswordHasher(argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}),
+	}
+	hash, _ := cfg.hasher.hash("pwd")
+	usr, _ := dbHandle.CreateUser("usr@boot.dev", hash)
+
+	cases := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "correct password", body: `{"email":"usr@boot.dev","password":"pwd"}`, expected: 200},
+		{name: "email in another case", body: `{"email":"USR@boot.dev","password":"pwd"}`, expected: 200},
+		{name: "wrong password", body: `{"email":"usr@boot.dev","password":"wrong"}`, expected: 401},
+		{name: "unknown email", body: `{"email":"nobody@boot.dev","password":"pwd"}`, expected: 401},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		cfg.authenticateHandle(rec, httptest.NewRequest("POST", "/api/login", strings.NewReader(c.body)))
+		if rec.Code != c.expected {
+			t.Errorf("%s: status == %d, expected %d", c.name, rec.Code, c.expected)
+		}
+	}
+
+	session, _ := dbHandle.CreateRefreshToken(time.Now().Add(time.Hour), usr.Id)
+	req := httptest.NewRequest("POST", "/api/revoke", nil)
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	rec := httptest.NewRecorder()
+	cfg.revokeTokenHandle(rec, req)
+	if rec.Code != 204 {
+		t.Errorf("revoke status == %d, expected 204", rec.Code)
+	}
+	auditLog, _ := os.ReadFile("./audit.log")
+	if !strings.Contains(string(auditLog), "refresh_token:"+hashSecret(session.Token)[:16]) {
+		t.Errorf("audit log doesn't identify the revoked refresh token")
+	}
+	if strings.Contains(string(auditLog), session.Token[:8]) {
+		t.Errorf("audit log contains part of the refresh token")
+	}
+}
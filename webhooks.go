@@ -13,6 +13,7 @@ func (cfg *apiConfig) redWebhook(w http.ResponseWriter, r *http.Request) {
 	apiKey := r.Header.Get("Authorization")
 	apiKey = strings.TrimPrefix(apiKey, "ApiKey ")
 	if apiKey != cfg.polkaKey {
+		recordAudit(r, 0, auditRedUpgrade, "webhook:polka", outcomeDenied)
 		respondWithError(w, 401, "Invalid API Key")
 		return
 	}
@@ -51,12 +52,30 @@ func (cfg *apiConfig) redWebhook(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		wasRed := userToUpdate.RedStatus
 		userToUpdate.RedStatus = true
 		_, err = dbHandle.UpdateUser(params.Data.UserId, userToUpdate)
 		if err != nil {
+			recordAudit(r, 0, auditRedUpgrade, userTarget(params.Data.UserId), outcomeFailure)
 			respondWithError(w, 404, "User not found")
 			return
 		}
+		if !wasRed {
+			err = dbHandle.CreateNotification(database.Notification{
+				UserId: params.Data.UserId,
+				Type:   database.NotificationRedUpgrade,
+			})
+			if err != nil {
+				respondWithError(w, 500, fmt.Sprintf("DB error: %v", err))
+				return
+			}
+		}
+		err = dbHandle.RecordSubscriptionEvent(params.Data.UserId, params.Event)
+		if err != nil {
+			respondWithError(w, 500, fmt.Sprintf("DB error: %v", err))
+			return
+		}
+		recordAudit(r, 0, auditRedUpgrade, userTarget(params.Data.UserId), outcomeSuccess)
 		respondWithJSON(w, 200, "")
 		return
 	}